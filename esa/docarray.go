@@ -0,0 +1,164 @@
+// Document array and document listing
+package esa
+
+import (
+	"sort"
+)
+
+const rmqBlockSize = 32
+
+// Range minimum query structure. Minima of whole blocks are kept in a sparse table, the
+// partial blocks at both ends of the range are scanned.
+type rangeMin struct {
+	values []int32
+	table  [][]int32 // table[k][b] is index of minimum of values in blocks b, ..., b+2^k-1
+}
+
+// Document array assigns document index to each suffix of the suffix array. Along with
+// the positions of the previous suffix of the same document it allows listing distinct
+// documents in an interval of the suffix array in time proportional to their number.
+type DocumentArray struct {
+	DA      []int32 // DA[i] is the index of the document containing suffix SA[i]
+	prev    []int32 // prev[i] is the greatest j < i with DA[j] == DA[i] or -1 if there's none
+	rmq     *rangeMin
+	wavelet *waveletMatrix // for top-k most frequent documents
+}
+
+func newRangeMin(values []int32) *rangeMin {
+	r := &rangeMin{values: values}
+	blocks := (len(values) + rmqBlockSize - 1) / rmqBlockSize
+	level := make([]int32, blocks)
+	for b := range level {
+		start := int32(b * rmqBlockSize)
+		level[b] = r.scan(start, min32(start+rmqBlockSize, int32(len(values))))
+	}
+	r.table = append(r.table, level)
+	for width := 2; width <= blocks; width *= 2 {
+		prevLevel := r.table[len(r.table)-1]
+		level = make([]int32, blocks-width+1)
+		for b := range level {
+			level[b] = r.minIndex(prevLevel[b], prevLevel[b+width/2])
+		}
+		r.table = append(r.table, level)
+	}
+	return r
+}
+
+func (r *rangeMin) minIndex(i, j int32) int32 {
+	if r.values[j] < r.values[i] {
+		return j
+	}
+	return i
+}
+
+func (r *rangeMin) scan(start, end int32) int32 {
+	m := start
+	for i := start + 1; i < end; i++ {
+		m = r.minIndex(m, i)
+	}
+	return m
+}
+
+// Index of the minimum in [start, end), end must be greater than start
+func (r *rangeMin) argMin(start, end int32) int32 {
+	firstBlock := (start + rmqBlockSize - 1) / rmqBlockSize
+	lastBlock := end / rmqBlockSize
+	if firstBlock >= lastBlock {
+		return r.scan(start, end)
+	}
+	k := 0
+	for 1<<uint(k+1) <= lastBlock-firstBlock {
+		k++
+	}
+	m := r.minIndex(r.table[k][firstBlock], r.table[k][lastBlock-1<<uint(k)])
+	if start < firstBlock*rmqBlockSize {
+		m = r.minIndex(r.scan(start, firstBlock*rmqBlockSize), m)
+	}
+	if lastBlock*rmqBlockSize < end {
+		m = r.minIndex(m, r.scan(lastBlock*rmqBlockSize, end))
+	}
+	return m
+}
+
+// Computes document array of the suffix array of data combined from documents starting at offsets
+func (esa *EnhancedSuffixArray) ComputeDocumentArray(offsets []int32) {
+	n := len(esa.SA) - 1
+	da := &DocumentArray{DA: make([]int32, n), prev: make([]int32, n)}
+	last := make([]int32, len(offsets))
+	for i := range last {
+		last[i] = UNDEF
+	}
+	for i := 0; i < n; i++ {
+		pos := esa.SA[i]
+		doc := int32(sort.Search(len(offsets), func(j int) bool { return offsets[j] > pos })) - 1
+		da.DA[i] = doc
+		da.prev[i] = last[doc]
+		last[doc] = int32(i)
+	}
+	da.rmq = newRangeMin(da.prev)
+	da.wavelet = newWaveletMatrix(da.DA, int32(len(offsets)-1))
+	esa.Documents = da
+}
+
+// Calls consumer with each distinct document of the suffixes in [start, end) exactly once, in
+// no particular order. The suffix with prev[i] < start is the first one of its document in the
+// range and the minimum of prev in any range is such suffix, if there's any in the range.
+func (da *DocumentArray) ForEachDistinct(start, end int32, consumer func(doc int32)) {
+	da.forEachDistinct(start, start, end, consumer)
+}
+
+func (da *DocumentArray) forEachDistinct(queryStart, start, end int32, consumer func(doc int32)) {
+	if start >= end {
+		return
+	}
+	m := da.rmq.argMin(start, end)
+	if da.prev[m] >= queryStart {
+		return
+	}
+	consumer(da.DA[m])
+	da.forEachDistinct(queryStart, start, m, consumer)
+	da.forEachDistinct(queryStart, m+1, end, consumer)
+}
+
+// Distinct documents of the suffixes in [start, end) in ascending order
+func (da *DocumentArray) Distinct(start, end int32) []int32 {
+	r := make([]int32, 0)
+	da.ForEachDistinct(start, end, func(doc int32) {
+		r = append(r, doc)
+	})
+	sort.Slice(r, func(i, j int) bool { return r[i] < r[j] })
+	return r
+}
+
+// Number of distinct documents of the suffixes in [start, end)
+func (da *DocumentArray) CountDistinct(start, end int32) int {
+	r := 0
+	da.ForEachDistinct(start, end, func(doc int32) {
+		r++
+	})
+	return r
+}
+
+// Number of suffixes of the document in [start, end)
+func (da *DocumentArray) Count(start, end, doc int32) int {
+	return int(da.wavelet.count(start, end, doc))
+}
+
+// Calls consumer with each suffix of the document in [start, end) in descending order. The last
+// one is found in the wavelet matrix, the others by following prev, so it takes time
+// proportional to their number rather than to the size of the range.
+func (da *DocumentArray) ForEachSuffix(start, end, doc int32, consumer func(i int32)) {
+	s, e := da.wavelet.valueRange(start, end, doc)
+	if s == e {
+		return
+	}
+	for i := da.wavelet.origin(e-1, doc); i >= start; i = da.prev[i] {
+		consumer(i)
+	}
+}
+
+// Returns at most k documents with the most suffixes in [start, end) along with the numbers of
+// these suffixes, ordered by the number descending, then by document index.
+func (da *DocumentArray) TopK(start, end int32, k int) ([]int32, []int32) {
+	return da.wavelet.topK(start, end, k)
+}
@@ -3,6 +3,7 @@ package esa
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"log"
 	"sort"
@@ -16,6 +17,8 @@ const (
 	CUNDEF = int16(-1)
 )
 
+var ErrEmptyPattern = errors.New("You must specify non-empty pattern")
+
 type int32stack []int32
 
 func (s int32stack) Peek() int32 {
@@ -45,6 +48,7 @@ type EnhancedSuffixArray struct {
 	Up           []int32
 	Down         []int32
 	Next         []int32
+	Documents    *DocumentArray // Optional document array of multi-document suffix array
 	rootInterval Interval
 }
 
@@ -399,6 +403,20 @@ func (esa *EnhancedSuffixArray) forEachChild(parent *Interval, consumer func(*In
 	}
 }
 
+// Calls consumer for each child interval of the given lcp-interval
+func (esa *EnhancedSuffixArray) ForEachChild(parent *Interval, consumer func(*Interval)) {
+	esa.forEachChild(parent, consumer)
+}
+
+// Returns the lcp-interval [start, end) along with the length of the longest common
+// prefix of its suffixes. For singleton interval it's the length of the only suffix.
+func (esa *EnhancedSuffixArray) LcpInterval(start, end int32) *Interval {
+	if start+1 == end {
+		return &Interval{int32(len(esa.Data)) - esa.SA[start], start, end}
+	}
+	return esa.interval(start, end)
+}
+
 func (esa *EnhancedSuffixArray) Match(pattern []byte, dataOff int32, patternOff int32, mlen int32) bool {
 	for i := int32(0); i < mlen; i++ {
 		pIdx := patternOff + i
@@ -417,10 +435,18 @@ func min32(a, b int32) int32 {
 	return b
 }
 
+// Find returning error instead of panicking on empty pattern
+func (esa *EnhancedSuffixArray) TryFind(pattern []byte, match func([]byte, int32, int32, int32) bool) (*Interval, error) {
+	if len(pattern) == 0 {
+		return nil, ErrEmptyPattern
+	}
+	return esa.Find(pattern, match), nil
+}
+
 func (esa *EnhancedSuffixArray) Find(pattern []byte, match func([]byte, int32, int32, int32) bool) *Interval {
 	plen := int32(len(pattern))
 	if pattern == nil || plen == 0 {
-		panic("You must specify non-empty pattern")
+		panic(ErrEmptyPattern)
 	}
 	c := int32(0)
 	queryFound := true
@@ -446,6 +472,133 @@ func (esa *EnhancedSuffixArray) Find(pattern []byte, match func([]byte, int32, i
 	return nil
 }
 
+// Finds intervals of all of the patterns with one traversal of the lcp-interval tree.
+// The patterns are processed in sorted order so that the patterns sharing a prefix
+// share the descent to the interval of that prefix. The i-th returned interval belongs
+// to the i-th pattern and is nil if the pattern wasn't found.
+func (esa *EnhancedSuffixArray) FindAll(patterns [][]byte, match func([]byte, int32, int32, int32) bool) []*Interval {
+	order := make([]int, len(patterns))
+	for i := range patterns {
+		if len(patterns[i]) == 0 {
+			panic(ErrEmptyPattern)
+		}
+		order[i] = i
+	}
+	sort.Slice(order, func(a, b int) bool {
+		return bytes.Compare(patterns[order[a]], patterns[order[b]]) < 0
+	})
+	r := make([]*Interval, len(patterns))
+	esa.findAll(&esa.rootInterval, 0, patterns, order, r, match)
+	return r
+}
+
+// All patterns in order are longer than c and share the prefix of length c of the parent interval.
+func (esa *EnhancedSuffixArray) findAll(parent *Interval, c int32, patterns [][]byte, order []int, r []*Interval, match func([]byte, int32, int32, int32) bool) {
+	for lo := 0; lo < len(order); {
+		edge := patterns[order[lo]][c]
+		hi := lo + 1
+		for hi < len(order) && patterns[order[hi]][c] == edge {
+			hi++
+		}
+		child := esa.getInterval(parent, int16(edge))
+		if child != nil {
+			esa.findAllInChild(child, c, patterns, order[lo:hi], r, match)
+		}
+		lo = hi
+	}
+}
+
+func (esa *EnhancedSuffixArray) findAllInChild(child *Interval, c int32, patterns [][]byte, order []int, r []*Interval, match func([]byte, int32, int32, int32) bool) {
+	leaf := child.End-child.Start <= 1
+	var deeper []int
+	for _, idx := range order {
+		pattern := patterns[idx]
+		plen := int32(len(pattern))
+		end := plen
+		if !leaf {
+			end = min32(child.Length, plen)
+		}
+		if !match(pattern, esa.SA[child.Start]+c, c, end-c) {
+			continue
+		}
+		if end == plen {
+			r[idx] = &Interval{plen, child.Start, child.End}
+		} else {
+			deeper = append(deeper, idx)
+		}
+	}
+	if len(deeper) > 0 {
+		esa.findAll(child, child.Length, patterns, deeper, r, match)
+	}
+}
+
+func caseVariants(c byte) []byte {
+	if c >= 'a' && c <= 'z' {
+		return []byte{c, c - 'a' + 'A'}
+	} else if c >= 'A' && c <= 'Z' {
+		return []byte{c, c - 'A' + 'a'}
+	}
+	return []byte{c}
+}
+
+func foldByte(c byte) byte {
+	if c >= 'A' && c <= 'Z' {
+		return c - 'A' + 'a'
+	}
+	return c
+}
+
+func (esa *EnhancedSuffixArray) foldMatch(pattern []byte, dataOff int32, patternOff int32, mlen int32) bool {
+	for i := int32(0); i < mlen; i++ {
+		pIdx := patternOff + i
+		dIdx := dataOff + i
+		if pIdx >= int32(len(pattern)) || dIdx >= int32(len(esa.Data)) || foldByte(pattern[pIdx]) != foldByte(esa.Data[dIdx]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Finds intervals of all strings equal to the pattern when ignoring case of ASCII letters.
+// At each branching point the search continues with both case variants of the next letter.
+// The match function is applied to the variant found in data, so it only has to check
+// constraints other than equality.
+func (esa *EnhancedSuffixArray) FindFold(pattern []byte, match func([]byte, int32, int32, int32) bool) []*Interval {
+	if pattern == nil || len(pattern) == 0 {
+		panic(ErrEmptyPattern)
+	}
+	r := make([]*Interval, 0)
+	esa.findFold(&esa.rootInterval, 0, pattern, make([]byte, len(pattern)), match, &r)
+	return r
+}
+
+func (esa *EnhancedSuffixArray) findFold(parent *Interval, c int32, pattern []byte, variant []byte, match func([]byte, int32, int32, int32) bool, r *[]*Interval) {
+	plen := int32(len(pattern))
+	for _, edge := range caseVariants(pattern[c]) {
+		child := esa.getInterval(parent, int16(edge))
+		if child == nil {
+			continue
+		}
+		end := plen
+		if child.End-child.Start > 1 {
+			end = min32(child.Length, plen)
+		}
+		start := esa.SA[child.Start]
+		if !esa.foldMatch(pattern, start+c, c, end-c) {
+			continue
+		}
+		copy(variant[c:end], esa.Data[start+c:start+end])
+		if !match(variant, start+c, c, end-c) {
+			continue
+		}
+		if end == plen {
+			*r = append(*r, &Interval{plen, child.Start, child.End})
+		} else {
+			esa.findFold(child, child.Length, pattern, variant, match, r)
+		}
+	}
+}
+
 type sortableBA [][]byte
 
 func (b sortableBA) Len() int {
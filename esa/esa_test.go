@@ -1,6 +1,8 @@
 package esa
 
 import (
+	"fmt"
+	"strings"
 	"testing"
 )
 
@@ -26,3 +28,151 @@ func TestSuffixArray(t *testing.T) {
 	}
 
 }
+
+func TestFindAll(t *testing.T) {
+	esa, err := New([]byte("mississippi"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	patterns := []string{"ssi", "i", "issi", "x", "mississippi", "is", "sip", "ississippix", "ppi", "s", "issippi"}
+	bpatterns := make([][]byte, len(patterns))
+	for i := range patterns {
+		bpatterns[i] = []byte(patterns[i])
+	}
+	intervals := esa.FindAll(bpatterns, esa.Match)
+	for i := range patterns {
+		expected := esa.Find(bpatterns[i], esa.Match)
+		computed := intervals[i]
+		if (expected == nil) != (computed == nil) || (expected != nil && *expected != *computed) {
+			t.Errorf("for pattern %v expected interval %v computed %v", patterns[i], expected, computed)
+		}
+	}
+}
+
+func TestTryFind(t *testing.T) {
+	esa, err := New([]byte("mississippi"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := esa.TryFind(nil, esa.Match); err != ErrEmptyPattern {
+		t.Errorf("expected empty pattern error but got %v", err)
+	}
+	if intv, err := esa.TryFind([]byte("ssi"), esa.Match); err != nil || intv.End-intv.Start != 2 {
+		t.Errorf("unexpected interval %v %v", intv, err)
+	}
+}
+
+func TestDocumentArray(t *testing.T) {
+	testDocumentArray(t, 1, "banana", "apple", "ananas", "cabana", "an", "pineapple")
+	var docs []string
+	for i := 0; i < 40; i++ {
+		docs = append(docs, strings.Repeat(string(rune('a'+i%7)), i%5+1)+"xyz"+strings.Repeat("ab", i%3))
+	}
+	testDocumentArray(t, 7, docs...)
+}
+
+func testDocumentArray(t *testing.T, step int32, docs ...string) {
+	var combined []byte
+	offsets := make([]int32, len(docs))
+	for i, doc := range docs {
+		offsets[i] = int32(len(combined))
+		combined = append(combined, doc...)
+	}
+	esa, _, err := NewMulti(combined, offsets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	esa.ComputeDocumentArray(offsets)
+	n := int32(len(esa.SA) - 1)
+	for start := int32(0); start < n; start += step {
+		for end := start; end <= n; end++ {
+			expected := make(map[int32]bool)
+			for i := start; i < end; i++ {
+				expected[esa.Documents.DA[i]] = true
+			}
+			computed := esa.Documents.Distinct(start, end)
+			if len(computed) != len(expected) || esa.Documents.CountDistinct(start, end) != len(expected) {
+				t.Fatalf("for [%v, %v) expected %v distinct documents got %v", start, end, len(expected), computed)
+			}
+			for i, doc := range computed {
+				if !expected[doc] || (i > 0 && computed[i-1] >= doc) {
+					t.Fatalf("for [%v, %v) got unexpected documents %v", start, end, computed)
+				}
+			}
+			for doc := int32(0); doc < int32(len(docs)); doc++ {
+				var suffixes []int32
+				for i := end - 1; i >= start; i-- {
+					if esa.Documents.DA[i] == doc {
+						suffixes = append(suffixes, i)
+					}
+				}
+				var computed []int32
+				esa.Documents.ForEachSuffix(start, end, doc, func(i int32) {
+					computed = append(computed, i)
+				})
+				if fmt.Sprint(computed) != fmt.Sprint(suffixes) || esa.Documents.Count(start, end, doc) != len(suffixes) {
+					t.Fatalf("for [%v, %v) expected suffixes %v of document %v but got %v", start, end, suffixes, doc, computed)
+				}
+			}
+		}
+	}
+	for i := int32(0); i < n; i++ {
+		pos := esa.SA[i]
+		doc := esa.Documents.DA[i]
+		if pos < offsets[doc] || (int(doc) < len(offsets)-1 && pos >= offsets[doc+1]) {
+			t.Errorf("suffix %v at position %v assigned to document %v", i, pos, doc)
+		}
+	}
+}
+
+func TestWaveletTopK(t *testing.T) {
+	values := []int32{3, 1, 4, 1, 5, 9, 2, 6, 5, 3, 5, 8, 9, 7, 9, 3, 2, 3, 8, 4, 6, 2, 6, 4, 3}
+	wm := newWaveletMatrix(values, 9)
+	for start := int32(0); start < int32(len(values)); start++ {
+		for end := start; end <= int32(len(values)); end++ {
+			freq := make(map[int32]int32)
+			for _, v := range values[start:end] {
+				freq[v]++
+			}
+			docs, counts := wm.topK(start, end, 3)
+			if expected := len(freq); (expected > 3 && len(docs) != 3) || (expected <= 3 && len(docs) != expected) {
+				t.Fatalf("for [%v, %v) got %v values", start, end, len(docs))
+			}
+			for i := range docs {
+				if freq[docs[i]] != counts[i] {
+					t.Fatalf("for [%v, %v) value %v has frequency %v not %v", start, end, docs[i], freq[docs[i]], counts[i])
+				}
+				if i > 0 && (counts[i-1] < counts[i] || (counts[i-1] == counts[i] && docs[i-1] > docs[i])) {
+					t.Fatalf("for [%v, %v) wrong order %v %v", start, end, docs, counts)
+				}
+			}
+			for v, f := range freq {
+				if len(docs) > 0 && f > counts[len(docs)-1] {
+					found := false
+					for _, d := range docs {
+						found = found || d == v
+					}
+					if !found {
+						t.Fatalf("for [%v, %v) value %v with frequency %v missing in %v %v", start, end, v, f, docs, counts)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestFindFold(t *testing.T) {
+	esa, err := New([]byte("Abra aBRa abra ABRACADABRA zabr"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for pattern, expected := range map[string]int{"abra": 5, "ABRA": 5, "abrac": 1, "bra ": 4, "zz": 0, "r": 6, " z": 1} {
+		total := 0
+		for _, intv := range esa.FindFold([]byte(pattern), esa.Match) {
+			total += int(intv.End - intv.Start)
+		}
+		if total != expected {
+			t.Errorf("for pattern %v expected %v hits, found %v", pattern, expected, total)
+		}
+	}
+}
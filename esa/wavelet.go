@@ -0,0 +1,202 @@
+// Wavelet matrix over the document array
+package esa
+
+import (
+	"container/heap"
+	"math/bits"
+	"sort"
+)
+
+type bitVector struct {
+	words []uint64
+	ranks []int32 // ranks[i] is the number of ones in words[:i]
+}
+
+// Wavelet matrix represents a sequence of integers by one bit vector per bit of the values,
+// most significant bit first. Each level is stably partitioned by its bit, zeros first, so
+// any range of the sequence maps to one range per level and value prefix.
+type waveletMatrix struct {
+	levels []*bitVector
+	zeros  []int32 // number of zeros on each level
+}
+
+func newBitVector(n int) *bitVector {
+	return &bitVector{words: make([]uint64, n/64+1)}
+}
+
+func (bv *bitVector) set(i int) {
+	bv.words[i/64] |= 1 << uint(i%64)
+}
+
+func (bv *bitVector) computeRanks() {
+	bv.ranks = make([]int32, len(bv.words)+1)
+	for i, w := range bv.words {
+		bv.ranks[i+1] = bv.ranks[i] + int32(bits.OnesCount64(w))
+	}
+}
+
+// Number of ones in [0, i)
+func (bv *bitVector) rank1(i int32) int32 {
+	return bv.ranks[i/64] + int32(bits.OnesCount64(bv.words[i/64]&(1<<uint(i%64)-1)))
+}
+
+// Position of the one preceded by k ones
+func (bv *bitVector) select1(k int32) int32 {
+	w := sort.Search(len(bv.words), func(w int) bool { return bv.ranks[w+1] > k })
+	word := bv.words[w]
+	for r := k - bv.ranks[w]; r > 0; r-- {
+		word &= word - 1
+	}
+	return int32(w*64 + bits.TrailingZeros64(word))
+}
+
+// Position of the zero preceded by k zeros
+func (bv *bitVector) select0(k int32) int32 {
+	w := sort.Search(len(bv.words), func(w int) bool { return int32((w+1)*64)-bv.ranks[w+1] > k })
+	word := ^bv.words[w]
+	for r := k - (int32(w*64) - bv.ranks[w]); r > 0; r-- {
+		word &= word - 1
+	}
+	return int32(w*64 + bits.TrailingZeros64(word))
+}
+
+func newWaveletMatrix(values []int32, maxValue int32) *waveletMatrix {
+	wm := new(waveletMatrix)
+	height := bits.Len32(uint32(maxValue))
+	if height == 0 {
+		height = 1
+	}
+	current := make([]int32, len(values))
+	copy(current, values)
+	next := make([]int32, len(values))
+	for level := height - 1; level >= 0; level-- {
+		bv := newBitVector(len(current))
+		zeros := 0
+		for i, v := range current {
+			if v>>uint(level)&1 == 1 {
+				bv.set(i)
+			} else {
+				zeros++
+			}
+		}
+		bv.computeRanks()
+		z, o := 0, zeros
+		for _, v := range current {
+			if v>>uint(level)&1 == 1 {
+				next[o] = v
+				o++
+			} else {
+				next[z] = v
+				z++
+			}
+		}
+		wm.levels = append(wm.levels, bv)
+		wm.zeros = append(wm.zeros, int32(zeros))
+		current, next = next, current
+	}
+	return wm
+}
+
+func (wm *waveletMatrix) bit(value int32, level int) bool {
+	return value>>uint(len(wm.levels)-1-level)&1 == 1
+}
+
+// Occurrences of the value in [start, end) are adjacent after the last level, returns their range there
+func (wm *waveletMatrix) valueRange(start, end, value int32) (int32, int32) {
+	for level, bv := range wm.levels {
+		if wm.bit(value, level) {
+			start, end = wm.zeros[level]+bv.rank1(start), wm.zeros[level]+bv.rank1(end)
+		} else {
+			start, end = start-bv.rank1(start), end-bv.rank1(end)
+		}
+	}
+	return start, end
+}
+
+// Number of occurrences of the value in [start, end)
+func (wm *waveletMatrix) count(start, end, value int32) int32 {
+	s, e := wm.valueRange(start, end, value)
+	return e - s
+}
+
+// Position in the sequence of the occurrence of the value at position i after the last level
+func (wm *waveletMatrix) origin(i, value int32) int32 {
+	for level := len(wm.levels) - 1; level >= 0; level-- {
+		if wm.bit(value, level) {
+			i = wm.levels[level].select1(i - wm.zeros[level])
+		} else {
+			i = wm.levels[level].select0(i)
+		}
+	}
+	return i
+}
+
+// Range of values with the same prefix on one level of the matrix
+type waveletNode struct {
+	level      int
+	start, end int32
+	value      int32 // prefix of the values in the node
+}
+
+type waveletHeap []waveletNode
+
+func (h waveletHeap) Len() int {
+	return len(h)
+}
+
+// Larger ranges first, for the same size inner nodes before leaves and leaves by value
+func (h waveletHeap) Less(i, j int) bool {
+	si, sj := h[i].end-h[i].start, h[j].end-h[j].start
+	if si != sj {
+		return si > sj
+	}
+	if h[i].level != h[j].level {
+		return h[i].level < h[j].level
+	}
+	return h[i].value < h[j].value
+}
+
+func (h waveletHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+}
+
+func (h *waveletHeap) Push(x interface{}) {
+	*h = append(*h, x.(waveletNode))
+}
+
+func (h *waveletHeap) Pop() interface{} {
+	old := *h
+	r := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return r
+}
+
+// Returns at most k most frequent values in [start, end) along with their frequencies, the most
+// frequent first. The nodes are expanded largest first and a node's size bounds the frequency
+// of any value below it, so only the nodes larger than the k-th frequency are visited.
+func (wm *waveletMatrix) topK(start, end int32, k int) ([]int32, []int32) {
+	var values, counts []int32
+	queue := &waveletHeap{}
+	if start < end {
+		heap.Push(queue, waveletNode{0, start, end, 0})
+	}
+	for queue.Len() > 0 && len(values) < k {
+		node := heap.Pop(queue).(waveletNode)
+		if node.level == len(wm.levels) {
+			values = append(values, node.value)
+			counts = append(counts, node.end-node.start)
+			continue
+		}
+		bv := wm.levels[node.level]
+		onesStart, onesEnd := bv.rank1(node.start), bv.rank1(node.end)
+		zerosStart, zerosEnd := node.start-onesStart, node.end-onesEnd
+		if zerosStart < zerosEnd {
+			heap.Push(queue, waveletNode{node.level + 1, zerosStart, zerosEnd, node.value << 1})
+		}
+		if onesStart < onesEnd {
+			z := wm.zeros[node.level]
+			heap.Push(queue, waveletNode{node.level + 1, z + onesStart, z + onesEnd, node.value<<1 | 1})
+		}
+	}
+	return values, counts
+}
@@ -1,9 +1,118 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"flag"
 	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+
+	"github.com/mlinhard/exactly-index/search"
+	"github.com/mlinhard/exactly-index/server"
 )
 
 func main() {
-	fmt.Printf("This should be an indexing server sometime\n")
+	patternsFile := flag.String("patterns", "", "scan the given files for the patterns listed in this file, one pattern per line")
+	countOnly := flag.Bool("count", false, "print only the number of hits of each pattern found")
+	maxHits := flag.Int("max-hits", 0, "print at most this many hits of each pattern, 0 for all of them")
+	cacheBytes := flag.Int64("cache-bytes", 64<<20, "memory limit of the cache of search results served over HTTP, 0 disables the cache")
+	listen := flag.String("listen", "", "serve search requests for the given files over HTTP on this address")
+	flag.Parse()
+	if *maxHits < 0 {
+		fmt.Fprintf(os.Stderr, "Negative -max-hits %v\n", *maxHits)
+		os.Exit(2)
+	}
+	if *listen != "" {
+		if err := serve(*listen, flag.Args(), *cacheBytes); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if *patternsFile == "" {
+		fmt.Printf("This should be an indexing server sometime\n")
+		return
+	}
+	if err := scanPatterns(*patternsFile, flag.Args(), *countOnly, *maxHits); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+}
+
+func readPatterns(path string) ([][]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var patterns [][]byte
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		pattern := bytes.TrimSuffix(scanner.Bytes(), []byte("\r"))
+		if len(pattern) > 0 {
+			patterns = append(patterns, append([]byte(nil), pattern...))
+		}
+	}
+	return patterns, scanner.Err()
+}
+
+func loadSearch(paths []string) (search.BatchSearch, error) {
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("No files to search in")
+	}
+	var combined []byte
+	offsets := make([]int, len(paths))
+	for i, path := range paths {
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if len(paths) == 1 {
+			return search.NewSingle(path, content)
+		}
+		offsets[i] = len(combined)
+		combined = append(combined, content...)
+	}
+	return search.NewMulti(combined, offsets, paths)
+}
+
+func scanPatterns(patternsFile string, paths []string, countOnly bool, maxHits int) error {
+	patterns, err := readPatterns(patternsFile)
+	if err != nil {
+		return err
+	}
+	index, err := loadSearch(paths)
+	if err != nil {
+		return err
+	}
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	for i, result := range index.FindAll(patterns, search.MaxHits(maxHits)) {
+		if result.IsEmpty() {
+			continue
+		}
+		if countOnly {
+			fmt.Fprintf(out, "%s\t%v\n", patterns[i], result.Total())
+			continue
+		}
+		result.ForEachHit(func(hit search.Hit) bool {
+			fmt.Fprintf(out, "%s:%v:%s\n", hit.Document().Id, hit.Position(), patterns[i])
+			return true
+		})
+	}
+	return nil
+}
+
+func serve(address string, paths []string, cacheBytes int64) error {
+	index, err := loadSearch(paths)
+	if err != nil {
+		return err
+	}
+	if cacheBytes > 0 {
+		return http.ListenAndServe(address, server.New(search.NewCachingSearch(index, cacheBytes)))
+	}
+	return http.ListenAndServe(address, server.New(index))
 }
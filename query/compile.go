@@ -0,0 +1,56 @@
+package query
+
+import (
+	"errors"
+	"fmt"
+	"path"
+
+	"github.com/mlinhard/exactly-index/search"
+)
+
+var ErrUnknownNode = errors.New("Unknown query node")
+
+// Translates the abstract syntax tree to a query of the search package
+func Compile(node Node) search.Query {
+	switch n := node.(type) {
+	case *Literal:
+		return term(n)
+	case *And:
+		return search.And(compileAll(n.Operands)...)
+	case *Or:
+		return search.Or(compileAll(n.Operands)...)
+	case *Not:
+		return search.Not(Compile(n.Operand))
+	case *Near:
+		return search.Proximity(term(n.First), term(n.Second), search.WithinBytes(n.Distance))
+	case *IdFilter:
+		glob := n.Glob
+		return search.DocumentFilter(func(document *search.Document) bool {
+			matched, _ := path.Match(glob, document.Id)
+			return matched
+		})
+	}
+	panic(fmt.Errorf("%w %T", ErrUnknownNode, node))
+}
+
+func term(literal *Literal) search.Query {
+	return search.Term(literal.Value, search.Match(literal.Match), search.IgnoreCase(literal.IgnoreCase))
+}
+
+func compileAll(nodes []Node) []search.Query {
+	r := make([]search.Query, len(nodes))
+	for i, node := range nodes {
+		r[i] = Compile(node)
+	}
+	return r
+}
+
+// Parses the query text and evaluates it against the search
+func Run(s search.Search, text string) (result *search.BooleanResult, err error) {
+	node, err := Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	defer search.Recover(&err)
+	return search.Evaluate(s, Compile(node)), nil
+}
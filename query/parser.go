@@ -0,0 +1,503 @@
+// Query language for the search API
+//
+// A query is a combination of literals by the boolean operators AND, OR, NOT and by the
+// proximity operator NEAR/n. Juxtaposed queries are combined by AND, precedence from the
+// lowest is OR, AND, NEAR/n and NOT. Literals are bare words, quoted strings with escapes
+// \", \\, \n, \r, \t and \xHH or hexadecimal bytes such as 0x0aff or hex:"0a ff".
+// Modifiers word:, line:, icase: and case: apply to the following literal or parenthesized
+// query and id:glob matches documents with id matching the glob.
+//
+//	icase:"hello world" NEAR/10 word:foo AND NOT (bar OR 0xcafe) id:src/*.go
+package query
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/mlinhard/exactly-index/search"
+)
+
+// Node of the abstract syntax tree of a query
+type Node interface {
+	// Position of the node in the query text
+	Pos() int
+	String() string
+}
+
+type Literal struct {
+	Position   int
+	Value      []byte
+	Hex        bool // written as hexadecimal bytes
+	Match      search.MatchFlags
+	IgnoreCase bool
+}
+
+type And struct {
+	Position int
+	Operands []Node
+}
+
+type Or struct {
+	Position int
+	Operands []Node
+}
+
+type Not struct {
+	Position int
+	Operand  Node
+}
+
+// Literals at most Distance bytes apart, in any order
+type Near struct {
+	Position      int
+	First, Second *Literal
+	Distance      int
+}
+
+// Documents with id matching the glob pattern of path.Match
+type IdFilter struct {
+	Position int
+	Glob     string
+}
+
+type SyntaxError struct {
+	Position int // byte offset in the query text
+	Message  string
+}
+
+func (this *SyntaxError) Error() string {
+	return fmt.Sprintf("syntax error at position %v: %v", this.Position, this.Message)
+}
+
+func (this *Literal) Pos() int  { return this.Position }
+func (this *And) Pos() int      { return this.Position }
+func (this *Or) Pos() int       { return this.Position }
+func (this *Not) Pos() int      { return this.Position }
+func (this *Near) Pos() int     { return this.Position }
+func (this *IdFilter) Pos() int { return this.Position }
+
+func (this *Literal) String() string {
+	var b strings.Builder
+	if this.IgnoreCase {
+		b.WriteString("icase:")
+	}
+	if this.Match&search.WholeLine == search.WholeLine {
+		b.WriteString("line:")
+	}
+	if this.Match&search.WholeWord != 0 {
+		b.WriteString("word:")
+	}
+	if this.Hex {
+		b.WriteString("0x" + hex.EncodeToString(this.Value))
+	} else {
+		b.WriteString(quote(string(this.Value)))
+	}
+	return b.String()
+}
+
+func quote(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c == '\n':
+			b.WriteString("\\n")
+		case c == '\r':
+			b.WriteString("\\r")
+		case c == '\t':
+			b.WriteString("\\t")
+		case c < 0x20 || c == 0x7f:
+			fmt.Fprintf(&b, "\\x%02x", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+func join(operands []Node, operator string) string {
+	s := make([]string, len(operands))
+	for i, operand := range operands {
+		s[i] = operand.String()
+	}
+	return "(" + strings.Join(s, " "+operator+" ") + ")"
+}
+
+func (this *And) String() string {
+	return join(this.Operands, "AND")
+}
+
+func (this *Or) String() string {
+	return join(this.Operands, "OR")
+}
+
+func (this *Not) String() string {
+	return "NOT " + this.Operand.String()
+}
+
+func (this *Near) String() string {
+	return fmt.Sprintf("(%v NEAR/%v %v)", this.First, this.Distance, this.Second)
+}
+
+func (this *IdFilter) String() string {
+	return "id:" + quote(this.Glob)
+}
+
+type tokenKind int
+
+const (
+	tokenEnd tokenKind = iota
+	tokenWord
+	tokenString
+	tokenHex
+	tokenOpen
+	tokenClose
+	tokenAnd
+	tokenOr
+	tokenNot
+	tokenNear
+	tokenModifier
+)
+
+type token struct {
+	kind     tokenKind
+	position int
+	text     string // value of literals, name of modifiers
+	distance int    // of NEAR/n
+}
+
+var modifiers = map[string]bool{"word": true, "line": true, "icase": true, "case": true, "id": true, "hex": true}
+
+func isDelimiter(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '(' || c == ')' || c == '"'
+}
+
+func tokenize(text string) ([]token, error) {
+	r := make([]token, 0)
+	for i := 0; i < len(text); {
+		c := text[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(':
+			r = append(r, token{kind: tokenOpen, position: i})
+			i++
+		case c == ')':
+			r = append(r, token{kind: tokenClose, position: i})
+			i++
+		case c == '"':
+			value, end, err := unquote(text, i)
+			if err != nil {
+				return nil, err
+			}
+			r = append(r, token{kind: tokenString, position: i, text: value})
+			i = end
+		default:
+			start := i
+			for i < len(text) && !isDelimiter(text[i]) {
+				if text[i] == ':' && modifiers[text[start:i]] {
+					break
+				}
+				i++
+			}
+			if i < len(text) && text[i] == ':' {
+				r = append(r, token{kind: tokenModifier, position: start, text: text[start:i]})
+				i++
+				continue
+			}
+			t, err := word(text[start:i], start)
+			if err != nil {
+				return nil, err
+			}
+			r = append(r, t)
+		}
+	}
+	return append(r, token{kind: tokenEnd, position: len(text)}), nil
+}
+
+// Classifies a bare word as an operator, hexadecimal literal or plain word
+func word(w string, position int) (token, error) {
+	switch {
+	case w == "AND":
+		return token{kind: tokenAnd, position: position}, nil
+	case w == "OR":
+		return token{kind: tokenOr, position: position}, nil
+	case w == "NOT":
+		return token{kind: tokenNot, position: position}, nil
+	case w == "NEAR" || strings.HasPrefix(w, "NEAR/"):
+		n, err := strconv.Atoi(strings.TrimPrefix(w, "NEAR/"))
+		if err != nil || n < 0 {
+			return token{}, &SyntaxError{position, "expected NEAR/n with non-negative distance n"}
+		}
+		return token{kind: tokenNear, position: position, distance: n}, nil
+	case len(w) > 2 && (strings.HasPrefix(w, "0x") || strings.HasPrefix(w, "0X")):
+		value, err := HexPattern(w)
+		if err != nil {
+			return token{}, &SyntaxError{position, "invalid hexadecimal literal " + w}
+		}
+		return token{kind: tokenHex, position: position, text: string(value)}, nil
+	}
+	return token{kind: tokenWord, position: position, text: w}, nil
+}
+
+// Decodes pattern given as hexadecimal bytes with optional 0x prefix, whitespace between the
+// bytes is ignored, e.g. "7f 45 4c 46"
+func HexPattern(text string) ([]byte, error) {
+	text = strings.TrimSpace(text)
+	if strings.HasPrefix(text, "0x") || strings.HasPrefix(text, "0X") {
+		text = text[2:]
+	}
+	return hex.DecodeString(strings.Join(strings.Fields(text), ""))
+}
+
+// Decodes the quoted string starting at start, returns its value and the position after it
+func unquote(text string, start int) (string, int, error) {
+	var b bytes.Buffer
+	for i := start + 1; i < len(text); i++ {
+		c := text[i]
+		if c == '"' {
+			return b.String(), i + 1, nil
+		}
+		if c != '\\' {
+			b.WriteByte(c)
+			continue
+		}
+		if i+1 == len(text) {
+			break
+		}
+		i++
+		switch text[i] {
+		case '"', '\\':
+			b.WriteByte(text[i])
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 't':
+			b.WriteByte('\t')
+		case 'x':
+			if i+3 > len(text) {
+				return "", 0, &SyntaxError{i - 1, "incomplete \\x escape"}
+			}
+			value, err := hex.DecodeString(text[i+1 : i+3])
+			if err != nil {
+				return "", 0, &SyntaxError{i - 1, "invalid \\x escape"}
+			}
+			b.Write(value)
+			i += 2
+		default:
+			return "", 0, &SyntaxError{i - 1, fmt.Sprintf("unknown escape \\%c", text[i])}
+		}
+	}
+	return "", 0, &SyntaxError{start, "unterminated string"}
+}
+
+type parser struct {
+	tokens     []token
+	next       int
+	match      search.MatchFlags // modifiers in effect
+	ignoreCase bool
+}
+
+func (p *parser) peek() *token {
+	return &p.tokens[p.next]
+}
+
+func (p *parser) advance() *token {
+	t := &p.tokens[p.next]
+	if t.kind != tokenEnd {
+		p.next++
+	}
+	return t
+}
+
+// Parses the query text to its abstract syntax tree
+func Parse(text string) (Node, error) {
+	tokens, err := tokenize(text)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	if p.peek().kind == tokenEnd {
+		return nil, &SyntaxError{0, "empty query"}
+	}
+	node, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokenEnd {
+		return nil, &SyntaxError{t.position, "unexpected " + describe(t)}
+	}
+	return node, nil
+}
+
+func describe(t *token) string {
+	switch t.kind {
+	case tokenEnd:
+		return "end of query"
+	case tokenClose:
+		return "closing parenthesis"
+	case tokenAnd:
+		return "AND"
+	case tokenOr:
+		return "OR"
+	case tokenNear:
+		return "NEAR"
+	}
+	return "token"
+}
+
+func (p *parser) parseOr() (Node, error) {
+	first, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	operands := []Node{first}
+	for p.peek().kind == tokenOr {
+		p.advance()
+		operand, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		operands = append(operands, operand)
+	}
+	if len(operands) == 1 {
+		return first, nil
+	}
+	return &Or{first.Pos(), operands}, nil
+}
+
+func startsOperand(kind tokenKind) bool {
+	switch kind {
+	case tokenWord, tokenString, tokenHex, tokenOpen, tokenNot, tokenModifier:
+		return true
+	}
+	return false
+}
+
+func (p *parser) parseAnd() (Node, error) {
+	first, err := p.parseNear()
+	if err != nil {
+		return nil, err
+	}
+	operands := []Node{first}
+	for p.peek().kind == tokenAnd || startsOperand(p.peek().kind) {
+		if p.peek().kind == tokenAnd {
+			p.advance()
+		}
+		operand, err := p.parseNear()
+		if err != nil {
+			return nil, err
+		}
+		operands = append(operands, operand)
+	}
+	if len(operands) == 1 {
+		return first, nil
+	}
+	return &And{first.Pos(), operands}, nil
+}
+
+func (p *parser) parseNear() (Node, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokenNear {
+		near := p.advance()
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		first, ok1 := left.(*Literal)
+		second, ok2 := right.(*Literal)
+		if !ok1 || !ok2 {
+			return nil, &SyntaxError{near.position, "operands of NEAR must be literals"}
+		}
+		left = &Near{first.Position, first, second, near.distance}
+	}
+	return left, nil
+}
+
+func (p *parser) parseNot() (Node, error) {
+	if p.peek().kind != tokenNot {
+		return p.parsePrimary()
+	}
+	not := p.advance()
+	operand, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	return &Not{not.position, operand}, nil
+}
+
+func (p *parser) parsePrimary() (Node, error) {
+	t := p.advance()
+	switch t.kind {
+	case tokenWord, tokenString:
+		if t.text == "" {
+			return nil, &SyntaxError{t.position, "empty literal"}
+		}
+		return &Literal{t.position, []byte(t.text), false, p.match, p.ignoreCase}, nil
+	case tokenHex:
+		return &Literal{t.position, []byte(t.text), true, p.match, p.ignoreCase}, nil
+	case tokenOpen:
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if c := p.advance(); c.kind != tokenClose {
+			return nil, &SyntaxError{c.position, "expected closing parenthesis instead of " + describe(c)}
+		}
+		return node, nil
+	case tokenModifier:
+		return p.parseModifier(t)
+	}
+	return nil, &SyntaxError{t.position, "expected literal instead of " + describe(t)}
+}
+
+// Parses the operand of the modifier with the modifier in effect
+func (p *parser) parseModifier(modifier *token) (Node, error) {
+	if modifier.text == "id" {
+		t := p.advance()
+		if t.kind != tokenWord && t.kind != tokenString {
+			return nil, &SyntaxError{t.position, "expected glob after id:"}
+		}
+		if _, err := path.Match(t.text, ""); err != nil {
+			return nil, &SyntaxError{t.position, "invalid glob " + t.text}
+		}
+		return &IdFilter{modifier.position, t.text}, nil
+	}
+	if modifier.text == "hex" {
+		t := p.advance()
+		if t.kind != tokenWord && t.kind != tokenString {
+			return nil, &SyntaxError{t.position, "expected hexadecimal bytes after hex:"}
+		}
+		value, err := HexPattern(t.text)
+		if err != nil || len(value) == 0 {
+			return nil, &SyntaxError{t.position, "invalid hexadecimal literal " + t.text}
+		}
+		return &Literal{t.position, value, true, p.match, p.ignoreCase}, nil
+	}
+	match, ignoreCase := p.match, p.ignoreCase
+	switch modifier.text {
+	case "word":
+		p.match |= search.WholeWord
+	case "line":
+		p.match |= search.WholeLine
+	case "icase":
+		p.ignoreCase = true
+	case "case":
+		p.ignoreCase = false
+	}
+	node, err := p.parsePrimary()
+	p.match, p.ignoreCase = match, ignoreCase
+	return node, err
+}
@@ -0,0 +1,135 @@
+package query
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/mlinhard/exactly-index/search"
+)
+
+func testSearch(t *testing.T, ids []string, texts ...string) search.Search {
+	offsets := make([]int, len(texts))
+	combined := make([]byte, 0)
+	for i, text := range texts {
+		offsets[i] = len(combined)
+		combined = append(combined, text...)
+	}
+	s, err := search.NewMulti(combined, offsets, ids)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func assertParsed(t *testing.T, text, expected string) {
+	node, err := Parse(text)
+	if err != nil {
+		t.Errorf("Unexpected error parsing %q: %v", text, err)
+		return
+	}
+	if node.String() != expected {
+		t.Errorf("Expected %q to parse as %v but got %v", text, expected, node)
+	}
+}
+
+func assertSyntaxError(t *testing.T, text string, position int) {
+	_, err := Parse(text)
+	syntaxErr, ok := err.(*SyntaxError)
+	if !ok {
+		t.Errorf("Expected syntax error parsing %q but got %v", text, err)
+		return
+	}
+	if syntaxErr.Position != position {
+		t.Errorf("Expected syntax error in %q at %v but got %v", text, position, syntaxErr)
+	}
+}
+
+func TestParse(t *testing.T) {
+	assertParsed(t, "foo", `"foo"`)
+	assertParsed(t, "foo bar OR baz", `(("foo" AND "bar") OR "baz")`)
+	assertParsed(t, "foo AND (bar OR NOT baz)", `("foo" AND ("bar" OR NOT "baz"))`)
+	assertParsed(t, `"a \"b\"\n\x00" 0x0aFF`, `("a \"b\"\n\x00" AND 0x0aff)`)
+	assertParsed(t, "foo NEAR/5 bar", `("foo" NEAR/5 "bar")`)
+	assertParsed(t, "a NEAR/1 b OR c", `(("a" NEAR/1 "b") OR "c")`)
+	assertParsed(t, "icase:(word:foo case:bar) line:baz", `((icase:word:"foo" AND "bar") AND line:"baz")`)
+	assertParsed(t, `foo id:src/*.go id:"a b"`, `("foo" AND id:"src/*.go" AND id:"a b")`)
+	assertParsed(t, "http://x", `"http://x"`)
+	assertParsed(t, `hex:"7f 45 4c 46" OR hex:00ff word:hex:61`, `(0x7f454c46 OR (0x00ff AND word:0x61))`)
+}
+
+func TestSyntaxErrors(t *testing.T) {
+	assertSyntaxError(t, "", 0)
+	assertSyntaxError(t, "foo AND", 7)
+	assertSyntaxError(t, "(foo bar", 8)
+	assertSyntaxError(t, "foo)", 3)
+	assertSyntaxError(t, `foo "bar`, 4)
+	assertSyntaxError(t, `"a\qb"`, 2)
+	assertSyntaxError(t, `"\x4"`, 1)
+	assertSyntaxError(t, "0x123", 0)
+	assertSyntaxError(t, "a NEAR/x b", 2)
+	assertSyntaxError(t, "(a OR b) NEAR/3 c", 9)
+	assertSyntaxError(t, "NOT a NEAR/1 b", 6)
+	assertSyntaxError(t, "id:[a", 3)
+	assertSyntaxError(t, "word:", 5)
+	assertSyntaxError(t, `foo ""`, 4)
+	assertSyntaxError(t, `hex:"7f 4"`, 4)
+	assertSyntaxError(t, `hex:""`, 4)
+	assertSyntaxError(t, "hex:(a)", 4)
+}
+
+func assertDocuments(t *testing.T, s search.Search, text string, expected string) {
+	result, err := Run(s, text)
+	if err != nil {
+		t.Errorf("Unexpected error running %q: %v", text, err)
+		return
+	}
+	docs := make([]int, len(result.Documents))
+	for i, match := range result.Documents {
+		docs[i] = match.Document
+	}
+	if fmt.Sprint(docs) != expected {
+		t.Errorf("Expected %q to match documents %v but got %v", text, expected, docs)
+	}
+}
+
+func TestRun(t *testing.T) {
+	s := testSearch(t, []string{"src/a.go", "src/b.txt", "doc/c.go", "d.go"},
+		"Hello world", "hello\tfoobar", "foo bar\nbaz", "HELLO foo")
+	assertDocuments(t, s, "hello", "[1]")
+	assertDocuments(t, s, "icase:hello", "[0 1 3]")
+	assertDocuments(t, s, "icase:hello NOT foo", "[0]")
+	assertDocuments(t, s, "word:foo", "[2 3]")
+	assertDocuments(t, s, `"hello\tfoo" OR 0x62617a`, "[1 2]")
+	assertDocuments(t, s, "foo NEAR/1 bar", "[1 2]")
+	assertDocuments(t, s, "foo NEAR/0 bar", "[1]")
+	assertDocuments(t, s, "line:baz OR line:\"foo bar\"", "[2]")
+	assertDocuments(t, s, "id:src/* foo", "[1]")
+	assertDocuments(t, s, "id:*.go", "[3]")
+	assertDocuments(t, s, "id:*/*.go OR icase:hello", "[0 1 2 3]")
+	assertDocuments(t, s, `hex:"09 66"`, "[1]")
+	if _, err := Run(s, "foo OR"); err == nil {
+		t.Errorf("Expected syntax error")
+	}
+}
+
+type unknownNode struct{}
+
+func (this unknownNode) Pos() int {
+	return 0
+}
+
+func (this unknownNode) String() string {
+	return "?"
+}
+
+func TestCompileUnknownNode(t *testing.T) {
+	var err error
+	func() {
+		defer search.Recover(&err)
+		Compile(unknownNode{})
+	}()
+	if !errors.Is(err, ErrUnknownNode) {
+		t.Errorf("Expected recovered unknown node error but got %v", err)
+	}
+}
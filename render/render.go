@@ -0,0 +1,312 @@
+// Rendering of hits and snippets with highlighted patterns
+package render
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/mlinhard/exactly-index/search"
+)
+
+// Data of one rendered line passed to the line template. All strings are escaped.
+type Line struct {
+	Document       string // id of the document
+	Number         int
+	Text           string // content of the line with the highlights
+	Match          bool   // the line contains a highlight
+	TruncatedStart bool   // Text starts with the ellipsis
+	TruncatedEnd   bool   // Text ends with the ellipsis
+}
+
+type Renderer struct {
+	Escape    func(text []byte) string
+	Highlight func(text string, current bool) string // wraps escaped highlighted text
+	Ellipsis  string                                 // marks truncated lines
+	Separator string                                 // written between non-adjacent contexts
+	Template  *template.Template                     // renders Line, including the line break
+}
+
+const grepTemplate = `{{.Document}}{{if .Match}}:{{.Number}}:{{else}}-{{.Number}}-{{end}}{{.Text}}
+`
+
+const ansiTemplate = "\x1b[35m{{.Document}}\x1b[0m{{if .Match}}:\x1b[32m{{.Number}}\x1b[0m:{{else}}-\x1b[32m{{.Number}}\x1b[0m-{{end}}{{.Text}}\n"
+
+const htmlTemplate = `<div class="line{{if .Match}} match{{end}}"><span class="document">{{.Document}}</span><span class="number">{{.Number}}</span><span class="text">{{.Text}}</span></div>
+`
+
+// Replaces C0 and C1 control characters and invalid UTF-8 so that the text can't affect the terminal
+func escapeText(text []byte) string {
+	return strings.Map(func(r rune) rune {
+		if r < 0x20 && r != '\t' || r >= 0x7f && r <= 0x9f {
+			return '.'
+		}
+		return r
+	}, strings.ToValidUTF8(string(text), "�"))
+}
+
+// Plain text in the format of grep -n with file names, the highlights are not marked
+func Grep() *Renderer {
+	return &Renderer{
+		Escape:    escapeText,
+		Highlight: func(text string, current bool) string { return text },
+		Ellipsis:  "...",
+		Separator: "--\n",
+		Template:  template.Must(template.New("grep").Parse(grepTemplate)),
+	}
+}
+
+// Text for terminals with the highlights in bold red
+func ANSI() *Renderer {
+	return &Renderer{
+		Escape: escapeText,
+		Highlight: func(text string, current bool) string {
+			return "\x1b[1;31m" + text + "\x1b[0m"
+		},
+		Ellipsis:  "\x1b[2m...\x1b[0m",
+		Separator: "\x1b[36m--\x1b[0m\n",
+		Template:  template.Must(template.New("ansi").Parse(ansiTemplate)),
+	}
+}
+
+// HTML with the highlights in <mark> elements, the highlight of the current hit has class current
+func HTML() *Renderer {
+	return &Renderer{
+		Escape: func(text []byte) string {
+			return html.EscapeString(strings.ToValidUTF8(string(text), "�"))
+		},
+		Highlight: func(text string, current bool) string {
+			if current {
+				return `<mark class="current">` + text + "</mark>"
+			}
+			return "<mark>" + text + "</mark>"
+		},
+		Ellipsis:  "&hellip;",
+		Separator: "<hr>\n",
+		Template:  template.Must(template.New("html").Parse(htmlTemplate)),
+	}
+}
+
+// Copy of the renderer rendering lines by the template with the fields of Line
+func (this *Renderer) WithTemplate(text string) (*Renderer, error) {
+	t, err := template.New("custom").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	r := *this
+	r.Template = t
+	return &r, nil
+}
+
+// Merges overlapping highlights, the merged highlight is current if any of its parts is
+func mergeHighlights(highlights []search.Highlight) []search.Highlight {
+	sorted := make([]search.Highlight, len(highlights))
+	copy(sorted, highlights)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Start < sorted[j].Start })
+	r := make([]search.Highlight, 0, len(sorted))
+	for _, h := range sorted {
+		if last := len(r) - 1; last >= 0 && h.Start <= r[last].End {
+			if h.End > r[last].End {
+				r[last].End = h.End
+			}
+			r[last].Current = r[last].Current || h.Current
+		} else {
+			r = append(r, h)
+		}
+	}
+	return r
+}
+
+// Escaped content with the highlights
+func (this *Renderer) text(content []byte, highlights []search.Highlight) string {
+	var b strings.Builder
+	pos := 0
+	for _, h := range mergeHighlights(highlights) {
+		b.WriteString(this.Escape(content[pos:h.Start]))
+		b.WriteString(this.Highlight(this.Escape(content[h.Start:h.End]), h.Current))
+		pos = h.End
+	}
+	b.WriteString(this.Escape(content[pos:]))
+	return b.String()
+}
+
+func (this *Renderer) RenderLine(w io.Writer, document *search.Document, line *search.ContextLine) error {
+	text := this.text(line.Content, line.Highlights)
+	if line.TruncatedStart {
+		text = this.Ellipsis + text
+	}
+	if line.TruncatedEnd {
+		text += this.Ellipsis
+	}
+	return this.Template.Execute(w, &Line{
+		this.Escape([]byte(document.Id)),
+		line.Number,
+		text,
+		len(line.Highlights) > 0,
+		line.TruncatedStart,
+		line.TruncatedEnd})
+}
+
+func (this *Renderer) RenderContext(w io.Writer, ctx *search.StructuredContext) error {
+	for i := range ctx.Lines {
+		if err := this.RenderLine(w, ctx.Document, &ctx.Lines[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Renders the lines around the hit with all hits of its pattern highlighted
+func (this *Renderer) RenderHit(w io.Writer, hit search.Hit, linesBefore, linesAfter int) error {
+	return this.RenderContext(w, hit.StructuredContext(linesBefore, linesAfter))
+}
+
+// Renders the snippets line by line with the separator between them
+func (this *Renderer) RenderSnippets(w io.Writer, snippets []*search.Snippet) error {
+	for i, snippet := range snippets {
+		if i > 0 && this.Separator != "" {
+			if _, err := io.WriteString(w, this.Separator); err != nil {
+				return err
+			}
+		}
+		if err := this.RenderContext(w, SnippetContext(snippet)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Renders the flat context as a whole with the pattern highlighted
+func (this *Renderer) Inline(ctx search.HitContext) string {
+	content := make([]byte, 0, len(ctx.Before())+len(ctx.Pattern())+len(ctx.After()))
+	content = append(append(append(content, ctx.Before()...), ctx.Pattern()...), ctx.After()...)
+	r := this.text(content, []search.Highlight{{Start: ctx.HighlightStart(), End: ctx.HighlightEnd(), Current: true}})
+	if ctx.TruncatedBefore() {
+		r = this.Ellipsis + r
+	}
+	if ctx.TruncatedAfter() {
+		r += this.Ellipsis
+	}
+	return r
+}
+
+// Splits the snippet to lines with the highlights of its hits
+func SnippetContext(snippet *search.Snippet) *search.StructuredContext {
+	content := snippet.Document.Content
+	number := 1
+	if len(snippet.Hits) > 0 {
+		number = snippet.Hits[0].Line()
+		first := snippet.Hits[0].Position()
+		for i := snippet.Start; i < first; i++ {
+			if n := search.LineBreak(content, i); n > 0 {
+				number--
+				i += n - 1
+			}
+		}
+	}
+	r := &search.StructuredContext{Document: snippet.Document}
+	start := snippet.Start
+	for start <= snippet.End {
+		end := start
+		for end < snippet.End && search.LineBreak(content, end) == 0 {
+			end++
+		}
+		line := search.ContextLine{
+			Number:         number,
+			Start:          start,
+			End:            end,
+			Content:        content[start:end],
+			Highlights:     make([]search.Highlight, 0),
+			TruncatedStart: start == snippet.Start && start > 0 && content[start-1] != '\n' && content[start-1] != '\r',
+			TruncatedEnd:   end == snippet.End && end < len(content) && search.LineBreak(content, end) == 0,
+		}
+		for _, h := range snippet.Highlights {
+			hStart, hEnd := h.Start+snippet.Start, h.End+snippet.Start
+			if hStart < start {
+				hStart = start
+			}
+			if hEnd > end {
+				hEnd = end
+			}
+			if hStart < hEnd {
+				line.Highlights = append(line.Highlights, search.Highlight{Start: hStart - start, End: hEnd - start, Current: h.Current})
+			}
+		}
+		if end == snippet.End {
+			if end > start || len(r.Lines) == 0 {
+				r.Lines = append(r.Lines, line)
+			}
+			break
+		}
+		r.Lines = append(r.Lines, line)
+		start = end + search.LineBreak(content, end)
+		number++
+	}
+	return r
+}
+
+const hexDumpWidth = 16
+
+func printable(c byte) byte {
+	if c < 0x20 || c > 0x7e {
+		return '.'
+	}
+	return c
+}
+
+// Renders the context of the hit as hex dump with rows of 16 bytes aligned to the offsets in the
+// document. Each row has the offset, the bytes in hexadecimal and as ASCII characters, the bytes
+// of the hit are highlighted in both columns.
+func (this *Renderer) RenderHexDump(w io.Writer, hit search.Hit, bytesBefore, bytesAfter int) error {
+	ctx := hit.CharContext(bytesBefore, bytesAfter)
+	content := hit.Document().Content
+	start := hit.Position() - len(ctx.Before())
+	end := hit.Position() + len(ctx.Pattern()) + len(ctx.After())
+	patternStart, patternEnd := hit.Position(), hit.Position()+len(ctx.Pattern())
+	for row := start - start%hexDumpWidth; row < end; row += hexDumpWidth {
+		var hex, ascii strings.Builder
+		var hexRun, asciiRun strings.Builder // highlighted part in progress
+		flush := func() {
+			if hexRun.Len() > 0 {
+				hex.WriteString(this.Highlight(hexRun.String(), true))
+				ascii.WriteString(this.Highlight(this.Escape([]byte(asciiRun.String())), true))
+				hexRun.Reset()
+				asciiRun.Reset()
+			}
+		}
+		for i := row; i < row+hexDumpWidth; i++ {
+			gap := " "
+			if i%hexDumpWidth == hexDumpWidth/2 {
+				gap = "  "
+			}
+			if i < start || i >= end {
+				flush()
+				hex.WriteString(gap + "  ")
+				ascii.WriteString(" ")
+				continue
+			}
+			cell := fmt.Sprintf("%02x", content[i])
+			if i >= patternStart && i < patternEnd {
+				if hexRun.Len() > 0 {
+					hexRun.WriteString(gap)
+				} else {
+					hex.WriteString(gap)
+				}
+				hexRun.WriteString(cell)
+				asciiRun.WriteByte(printable(content[i]))
+			} else {
+				flush()
+				hex.WriteString(gap + cell)
+				ascii.WriteString(this.Escape([]byte{printable(content[i])}))
+			}
+		}
+		flush()
+		if _, err := fmt.Fprintf(w, "%08x %s  |%s|\n", row, hex.String(), ascii.String()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package render
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/mlinhard/exactly-index/search"
+)
+
+func testSearch(t *testing.T, texts ...string) search.Search {
+	offsets := make([]int, len(texts))
+	ids := make([]string, len(texts))
+	combined := make([]byte, 0)
+	for i, text := range texts {
+		offsets[i] = len(combined)
+		ids[i] = string(rune('a'+i)) + ".txt"
+		combined = append(combined, text...)
+	}
+	s, err := search.NewMulti(combined, offsets, ids)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func assertRendered(t *testing.T, render func(w *bytes.Buffer) error, expected string) {
+	var b bytes.Buffer
+	if err := render(&b); err != nil {
+		t.Error(err)
+		return
+	}
+	if b.String() != expected {
+		t.Errorf("Expected output\n%q\nbut got\n%q", expected, b.String())
+	}
+}
+
+func TestRenderHit(t *testing.T) {
+	s := testSearch(t, "one\nfoo <b> foo\r\nthree", "x")
+	hit := s.Find([]byte("foo")).HitWithPosition(0, 4)
+	assertRendered(t, func(w *bytes.Buffer) error { return Grep().RenderHit(w, hit, 1, 1) },
+		"a.txt-1-one\na.txt:2:foo <b> foo\na.txt-3-three\n")
+	assertRendered(t, func(w *bytes.Buffer) error { return HTML().RenderHit(w, hit, 0, 0) },
+		`<div class="line match"><span class="document">a.txt</span><span class="number">2</span>`+
+			`<span class="text"><mark class="current">foo</mark> &lt;b&gt; <mark>foo</mark></span></div>`+"\n")
+	assertRendered(t, func(w *bytes.Buffer) error { return ANSI().RenderHit(w, hit, 0, 0) },
+		"\x1b[35ma.txt\x1b[0m:\x1b[32m2\x1b[0m:\x1b[1;31mfoo\x1b[0m <b> \x1b[1;31mfoo\x1b[0m\n")
+	custom, err := HTML().WithTemplate("{{.Number}}|{{.Text}}\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertRendered(t, func(w *bytes.Buffer) error { return custom.RenderHit(w, hit, 0, 1) },
+		`2|<mark class="current">foo</mark> &lt;b&gt; <mark>foo</mark>`+"\n3|three\n")
+	if _, err := Grep().WithTemplate("{{.Number"); err == nil {
+		t.Errorf("Expected template error")
+	}
+}
+
+func TestRenderSnippets(t *testing.T) {
+	s := testSearch(t, "aa foo bb\nfoo\n", "\x1bfoo cc foo dd", longLine())
+	snippets := search.Snippets(s.Find([]byte("foo")), search.SnippetChars(3, 3))
+	assertRendered(t, func(w *bytes.Buffer) error { return Grep().RenderSnippets(w, snippets) },
+		"a.txt:1:aa foo bb\na.txt:2:foo\n--\nb.txt:1:.foo cc foo dd\n--\nc.txt:1:...yz foo xy...\n")
+	custom, _ := HTML().WithTemplate("{{.Number}}:{{.Text}}\n")
+	assertRendered(t, func(w *bytes.Buffer) error { return custom.RenderSnippets(w, snippets[:1]) },
+		"1:aa <mark class=\"current\">foo</mark> bb\n2:<mark class=\"current\">foo</mark>\n")
+}
+
+func longLine() string {
+	return "xyzxyzxyz foo xyzxyz"
+}
+
+func TestInline(t *testing.T) {
+	s := testSearch(t, "a & b\nfoo\nc < d", longLine())
+	hit := s.Find([]byte("foo")).HitWithPosition(0, 6)
+	if r := HTML().Inline(hit.CharContext(4, 4)); r != "&amp; b\n<mark class=\"current\">foo</mark>\nc &lt;" {
+		t.Errorf("Unexpected inline context %q", r)
+	}
+	long := s.Find([]byte("foo")).HitWithPosition(1, 10)
+	if r := Grep().Inline(long.BoundedLineContext(0, 0, 7)); r != "...z foo x..." {
+		t.Errorf("Unexpected inline context %q", r)
+	}
+}
+
+func TestRenderHexDump(t *testing.T) {
+	content := make([]byte, 40)
+	for i := range content {
+		content[i] = byte(i)
+	}
+	copy(content[14:], "ELF<")
+	hit := testSearch(t, string(content), "x").Find([]byte("ELF<")).Hit(0)
+	brackets := Grep()
+	brackets.Highlight = func(text string, current bool) string { return "[" + text + "]" }
+	assertRendered(t, func(w *bytes.Buffer) error { return brackets.RenderHexDump(w, hit, 4, 4) },
+		"00000000 "+strings.Repeat(" ", 31)+" 0a 0b 0c 0d [45 4c]  |"+strings.Repeat(" ", 10)+"....[EL]|\n"+
+			"00000010  [46 3c] 12 13 14 15"+strings.Repeat(" ", 31)+"  |[F<]...."+strings.Repeat(" ", 10)+"|\n")
+	html := HTML()
+	assertRendered(t, func(w *bytes.Buffer) error { return html.RenderHexDump(w, hit, 0, 2) },
+		"00000000 "+strings.Repeat(" ", 43)+` <mark class="current">45 4c</mark>  |`+strings.Repeat(" ", 14)+
+			`<mark class="current">EL</mark>|`+"\n"+
+			"00000010 "+` <mark class="current">46 3c</mark> 12 13`+strings.Repeat(" ", 37)+
+			`  |<mark class="current">F&lt;</mark>..`+strings.Repeat(" ", 12)+"|\n")
+}
+
+func TestEscapeControls(t *testing.T) {
+	if r := escapeText([]byte("a\x1b[31m\tb\u009b31m\u0085c\x7f\xffé")); r != "a.[31m\tb.31m.c.�é" {
+		t.Errorf("Unexpected escaped text %q", r)
+	}
+}
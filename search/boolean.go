@@ -0,0 +1,379 @@
+// Boolean document queries
+package search
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/mlinhard/exactly-index/esa"
+)
+
+// Query matching documents by presence of patterns combined with AND, OR and NOT
+type Query interface {
+	// Sorted indexes of the matching documents
+	documents(e *evaluation) []int
+	// Estimate of the number of matching documents used to order the evaluation
+	cost(e *evaluation) int
+	// Appends the terms not negated
+	positiveTerms(terms []*termQuery, negated bool) []*termQuery
+}
+
+type termQuery struct {
+	pattern []byte
+	options []FindOption
+}
+
+type andQuery []Query
+
+type orQuery []Query
+
+type notQuery struct {
+	query Query
+}
+
+type proximityQuery struct {
+	first, second *termQuery
+	within        Distance
+}
+
+type filterQuery struct {
+	accept func(document *Document) bool
+}
+
+// Documents containing the pattern
+func Term(pattern []byte, options ...FindOption) Query {
+	return &termQuery{pattern, options}
+}
+
+// Documents matching all of the queries
+func And(queries ...Query) Query {
+	return andQuery(queries)
+}
+
+// Documents matching any of the queries
+func Or(queries ...Query) Query {
+	return orQuery(queries)
+}
+
+// Documents not matching the query
+func Not(query Query) Query {
+	return &notQuery{query}
+}
+
+// Documents with hits of both terms within the distance, both queries must be created by Term
+func Proximity(first, second Query, within Distance) Query {
+	a, ok1 := first.(*termQuery)
+	b, ok2 := second.(*termQuery)
+	if !ok1 || !ok2 {
+		panic(fmt.Errorf("%w: proximity of queries other than terms", ErrInvalidQuery))
+	}
+	return &proximityQuery{a, b, within}
+}
+
+// Documents accepted by the function
+func DocumentFilter(accept func(document *Document) bool) Query {
+	return &filterQuery{accept}
+}
+
+type DocumentMatch struct {
+	Document int
+	Hits     [][]Hit // Hits[i] are the hits of the i-th positive term in the document, possibly none
+}
+
+type BooleanResult struct {
+	Terms     [][]byte // The terms of the query that are not negated
+	Documents []DocumentMatch
+}
+
+// Search able to bound the number of hits of a pattern by the size of its lcp intervals,
+// without finding the hits. Match restrictions and the hit limit are not applied.
+type hitCounter interface {
+	countHits(pattern []byte, options *FindOptions) int
+}
+
+// Upper bound of the number of hits, the pattern is searched for only if the search can't count them
+func estimateHits(search Search, pattern []byte, options *FindOptions) int {
+	if counter, ok := search.(hitCounter); ok {
+		return counter.countHits(pattern, options)
+	}
+	return search.Find(pattern, IgnoreCase(options.IgnoreCase)).Size()
+}
+
+// Number of suffixes in the intervals found, nil for a pattern not found
+func foundSize(intervals []*esa.Interval) int {
+	r := 0
+	for _, interval := range intervals {
+		if interval != nil {
+			r += int(interval.End - interval.Start)
+		}
+	}
+	return r
+}
+
+type evaluation struct {
+	search  Search
+	results map[*termQuery]SearchResult
+}
+
+func (e *evaluation) result(term *termQuery) SearchResult {
+	r, ok := e.results[term]
+	if !ok {
+		r = e.search.Find(term.pattern, term.options...)
+		e.results[term] = r
+	}
+	return r
+}
+
+func (e *evaluation) allDocuments() []int {
+	r := make([]int, e.search.DocumentCount())
+	for i := range r {
+		r[i] = i
+	}
+	return r
+}
+
+func intersectDocuments(a, b []int) []int {
+	r := make([]int, 0)
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		if a[i] < b[j] {
+			i++
+		} else if a[i] > b[j] {
+			j++
+		} else {
+			r = append(r, a[i])
+			i++
+			j++
+		}
+	}
+	return r
+}
+
+func unionDocuments(a, b []int) []int {
+	r := make([]int, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		if a[i] < b[j] {
+			r = append(r, a[i])
+			i++
+		} else if a[i] > b[j] {
+			r = append(r, b[j])
+			j++
+		} else {
+			r = append(r, a[i])
+			i++
+			j++
+		}
+	}
+	r = append(r, a[i:]...)
+	return append(r, b[j:]...)
+}
+
+func subtractDocuments(a, b []int) []int {
+	r := make([]int, 0, len(a))
+	j := 0
+	for _, doc := range a {
+		for j < len(b) && b[j] < doc {
+			j++
+		}
+		if j == len(b) || b[j] != doc {
+			r = append(r, doc)
+		}
+	}
+	return r
+}
+
+func (q *termQuery) documents(e *evaluation) []int {
+	return e.result(q).Documents()
+}
+
+// Number of hits bounds the number of documents. Unless the term was already found, it's
+// estimated from the index, so the terms an AND skips are never searched for.
+func (q *termQuery) cost(e *evaluation) int {
+	if r, ok := e.results[q]; ok {
+		return r.Size()
+	}
+	if counter, ok := e.search.(hitCounter); ok {
+		return counter.countHits(q.pattern, newFindOptions(q.options))
+	}
+	return e.result(q).Size()
+}
+
+func (q *termQuery) positiveTerms(terms []*termQuery, negated bool) []*termQuery {
+	if negated {
+		return terms
+	}
+	return append(terms, q)
+}
+
+// Evaluates the positive queries from the most selective one, then removes documents matching
+// the negated ones, stopping as soon as no document remains.
+func (q andQuery) documents(e *evaluation) []int {
+	var positive, negative []Query
+	for _, query := range q {
+		if not, ok := query.(*notQuery); ok {
+			negative = append(negative, not.query)
+		} else {
+			positive = append(positive, query)
+		}
+	}
+	sortByCost(e, positive)
+	sortByCost(e, negative)
+	var r []int
+	if len(positive) == 0 {
+		r = e.allDocuments()
+	} else {
+		r = positive[0].documents(e)
+		for _, query := range positive[1:] {
+			if len(r) == 0 {
+				return r
+			}
+			r = intersectDocuments(r, query.documents(e))
+		}
+	}
+	for _, query := range negative {
+		if len(r) == 0 {
+			return r
+		}
+		r = subtractDocuments(r, query.documents(e))
+	}
+	return r
+}
+
+func (q andQuery) cost(e *evaluation) int {
+	r := e.search.DocumentCount()
+	for _, query := range q {
+		if _, ok := query.(*notQuery); !ok {
+			if c := query.cost(e); c < r {
+				r = c
+			}
+		}
+	}
+	return r
+}
+
+func (q andQuery) positiveTerms(terms []*termQuery, negated bool) []*termQuery {
+	for _, query := range q {
+		terms = query.positiveTerms(terms, negated)
+	}
+	return terms
+}
+
+func (q orQuery) documents(e *evaluation) []int {
+	r := make([]int, 0)
+	for _, query := range q {
+		r = unionDocuments(r, query.documents(e))
+	}
+	return r
+}
+
+func (q orQuery) cost(e *evaluation) int {
+	r := 0
+	for _, query := range q {
+		r += query.cost(e)
+	}
+	return r
+}
+
+func (q orQuery) positiveTerms(terms []*termQuery, negated bool) []*termQuery {
+	for _, query := range q {
+		terms = query.positiveTerms(terms, negated)
+	}
+	return terms
+}
+
+func (q *notQuery) documents(e *evaluation) []int {
+	return subtractDocuments(e.allDocuments(), q.query.documents(e))
+}
+
+func (q *notQuery) cost(e *evaluation) int {
+	return e.search.DocumentCount()
+}
+
+func (q *notQuery) positiveTerms(terms []*termQuery, negated bool) []*termQuery {
+	return q.query.positiveTerms(terms, !negated)
+}
+
+func (q *proximityQuery) documents(e *evaluation) []int {
+	r := make([]int, 0)
+	for _, span := range Near(e.result(q.first), e.result(q.second), q.within) {
+		if len(r) == 0 || r[len(r)-1] != span.Document.Index {
+			r = append(r, span.Document.Index)
+		}
+	}
+	return r
+}
+
+func (q *proximityQuery) cost(e *evaluation) int {
+	if a, b := q.first.cost(e), q.second.cost(e); b < a {
+		return b
+	} else {
+		return a
+	}
+}
+
+func (q *proximityQuery) positiveTerms(terms []*termQuery, negated bool) []*termQuery {
+	return q.second.positiveTerms(q.first.positiveTerms(terms, negated), negated)
+}
+
+func (q *filterQuery) documents(e *evaluation) []int {
+	r := make([]int, 0)
+	for i := 0; i < e.search.DocumentCount(); i++ {
+		if q.accept(e.search.Document(i)) {
+			r = append(r, i)
+		}
+	}
+	return r
+}
+
+func (q *filterQuery) cost(e *evaluation) int {
+	return e.search.DocumentCount()
+}
+
+func (q *filterQuery) positiveTerms(terms []*termQuery, negated bool) []*termQuery {
+	return terms
+}
+
+func sortByCost(e *evaluation, queries []Query) {
+	costs := make([]int, len(queries))
+	for i := range queries {
+		costs[i] = queries[i].cost(e)
+	}
+	sort.Sort(&byCost{queries, costs})
+}
+
+type byCost struct {
+	queries []Query
+	costs   []int
+}
+
+func (b *byCost) Len() int {
+	return len(b.queries)
+}
+
+func (b *byCost) Less(i, j int) bool {
+	return b.costs[i] < b.costs[j]
+}
+
+func (b *byCost) Swap(i, j int) {
+	b.queries[i], b.queries[j] = b.queries[j], b.queries[i]
+	b.costs[i], b.costs[j] = b.costs[j], b.costs[i]
+}
+
+// Finds documents matching the query along with the hits of its positive terms
+func Evaluate(search Search, query Query) *BooleanResult {
+	e := &evaluation{search, make(map[*termQuery]SearchResult)}
+	terms := query.positiveTerms(nil, false)
+	r := &BooleanResult{Terms: make([][]byte, len(terms))}
+	for i, term := range terms {
+		r.Terms[i] = term.pattern
+	}
+	documents := query.documents(e)
+	r.Documents = make([]DocumentMatch, len(documents))
+	for i, doc := range documents {
+		r.Documents[i] = DocumentMatch{doc, make([][]Hit, len(terms))}
+		for j, term := range terms {
+			r.Documents[i].Hits[j] = e.result(term).DocumentHits(doc)
+		}
+	}
+	return r
+}
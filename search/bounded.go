@@ -0,0 +1,76 @@
+// Line contexts limited in size
+package search
+
+import (
+	"unicode/utf8"
+)
+
+// Hit context cut short of the line boundaries
+type truncatedContext struct {
+	*HitContextStruct
+	truncatedBefore, truncatedAfter bool
+}
+
+func (this *HitContextStruct) TruncatedBefore() bool {
+	return false
+}
+
+func (this *HitContextStruct) TruncatedAfter() bool {
+	return false
+}
+
+func (this *truncatedContext) TruncatedBefore() bool {
+	return this.truncatedBefore
+}
+
+func (this *truncatedContext) TruncatedAfter() bool {
+	return this.truncatedAfter
+}
+
+// True iff a line break ends at position i of the data, documents end at docEnd
+func lineBreakEndsAt(data []byte, i, docEnd int32) bool {
+	return data[i] == '\n' || data[i] == '\r' && (i+1 == docEnd || data[i+1] != '\n')
+}
+
+// Line context of at most maxBytes bytes including the pattern. The lines are looked up only
+// within maxBytes bytes around the hit. If they don't fit, the context is cut so that the hit
+// is in its middle, unless one side is shorter and leaves more room to the other one. The
+// pattern itself is never cut, a pattern longer than maxBytes is returned without any context.
+func boundedLineContext(result SearchResult, hitIdx int, linesBefore, linesAfter, maxBytes int) *truncatedContext {
+	if linesBefore < 0 || linesAfter < 0 || maxBytes < 0 {
+		panic(ErrNegativeContext)
+	}
+	window := result.charContext(hitIdx, maxBytes, maxBytes).(*HitContextStruct)
+	data := window.data
+	windowData := data[window.position : window.position+window.lenBefore+window.lenPattern+window.lenAfter]
+	patternStart, patternEnd := window.lenBefore, window.lenBefore+window.lenPattern
+	before := patternStart - linesBeforeStartAt(windowData, patternStart, linesBefore)
+	after := linesAfterStartAt(windowData, patternEnd, linesAfter) - patternEnd
+	remaining := max32(int32(maxBytes)-window.lenPattern, 0)
+	if before+after > remaining {
+		half := remaining / 2
+		if before <= half {
+			after = remaining - before
+		} else if after <= remaining-half {
+			before = remaining - after
+		} else {
+			before, after = half, remaining-half
+		}
+	}
+	patternStart += window.position
+	patternEnd += window.position
+	start, end := patternStart-before, patternEnd+after
+	for start < patternStart && !utf8.RuneStart(data[start]) {
+		start++
+	}
+	for end > patternEnd && end < int32(len(data)) && !utf8.RuneStart(data[end]) {
+		end--
+	}
+	docEnd := window.offset + int32(len(result.document(hitIdx).Content))
+	r := *window
+	r.position, r.lenBefore, r.lenAfter = start, patternStart-start, end-patternEnd
+	return &truncatedContext{
+		&r,
+		start > window.offset && !lineBreakEndsAt(data, start-1, docEnd),
+		end < docEnd && !isNewLineChar(data[end])}
+}
@@ -0,0 +1,150 @@
+// Cache of search results
+package search
+
+import (
+	"container/list"
+	"sync"
+)
+
+// Estimated memory of a cached result apart from its hits, and the upper bound of the memory of
+// each hit held by the result or by the results it's built on. A hit takes at most 4 bytes of
+// hit index in a subset or union, 4 bytes of document index cache, 4 bytes of position index and
+// 12 bytes of its grouping by document, whether the caches built on first use exist yet or not.
+// Line indexes belong to the search and are shared by all of its results, so they aren't charged.
+const (
+	cachedResultBytes = 256
+	cachedHitBytes    = 24
+)
+
+// Search keeping the results of recent Find calls in memory. When the memory estimate of the
+// cached results exceeds the limit, the least recently used ones are dropped. The results are
+// shared by the callers finding the same pattern with the same options.
+type CachingSearch struct {
+	mutex      sync.Mutex
+	search     Search
+	generation int // incremented by each invalidation
+	maxBytes   int64
+	bytes      int64
+	entries    map[cacheKey]*list.Element
+	lru        *list.List // of *cacheEntry, the most recently used first
+	stats      CacheStats
+}
+
+type CacheStats struct {
+	Hits      int64
+	Misses    int64
+	Evictions int64 // results dropped to keep the memory limit
+	Entries   int
+	Bytes     int64 // memory estimate of the cached results
+}
+
+type cacheKey struct {
+	pattern string
+	options FindOptions
+}
+
+type cacheEntry struct {
+	key    cacheKey
+	result SearchResult
+	bytes  int64
+}
+
+func NewCachingSearch(search Search, maxBytes int64) *CachingSearch {
+	return &CachingSearch{search: search, maxBytes: maxBytes, entries: make(map[cacheKey]*list.Element), lru: list.New()}
+}
+
+func resultBytes(pattern []byte, result SearchResult) int64 {
+	return cachedResultBytes + int64(len(pattern)) + cachedHitBytes*int64(result.heldHits())
+}
+
+// Replaces the underlying search and drops all cached results
+func (this *CachingSearch) Replace(search Search) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.search = search
+	this.invalidate()
+}
+
+// Drops all cached results, needed when the documents of the underlying search change
+func (this *CachingSearch) Invalidate() {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.invalidate()
+}
+
+func (this *CachingSearch) invalidate() {
+	this.generation++
+	this.entries = make(map[cacheKey]*list.Element)
+	this.lru.Init()
+	this.bytes = 0
+	this.stats.Entries = 0
+	this.stats.Bytes = 0
+}
+
+func (this *CachingSearch) Stats() CacheStats {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	return this.stats
+}
+
+func (this *CachingSearch) current() Search {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	return this.search
+}
+
+func (this *CachingSearch) DocumentCount() int {
+	return this.current().DocumentCount()
+}
+
+func (this *CachingSearch) Document(idx int) *Document {
+	return this.current().Document(idx)
+}
+
+// Finds the pattern in the underlying search unless the result is cached. The lock is not held
+// during the search, so concurrent misses of the same key may search in parallel.
+func (this *CachingSearch) Find(pattern []byte, options ...FindOption) SearchResult {
+	key := cacheKey{string(pattern), *newFindOptions(options)}
+	this.mutex.Lock()
+	if element, ok := this.entries[key]; ok {
+		this.lru.MoveToFront(element)
+		this.stats.Hits++
+		this.mutex.Unlock()
+		return element.Value.(*cacheEntry).result
+	}
+	this.stats.Misses++
+	search, generation := this.search, this.generation
+	this.mutex.Unlock()
+
+	result := search.Find(pattern, options...)
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	if generation == this.generation {
+		this.add(&cacheEntry{key, result, resultBytes(pattern, result)})
+	}
+	return result
+}
+
+func (this *CachingSearch) countHits(pattern []byte, options *FindOptions) int {
+	return estimateHits(this.current(), pattern, options)
+}
+
+// Adds the entry unless it's cached already or larger than the limit, then evicts the least
+// recently used entries until the limit is kept
+func (this *CachingSearch) add(entry *cacheEntry) {
+	if _, ok := this.entries[entry.key]; ok || entry.bytes > this.maxBytes {
+		return
+	}
+	this.entries[entry.key] = this.lru.PushFront(entry)
+	this.bytes += entry.bytes
+	for this.bytes > this.maxBytes {
+		oldest := this.lru.Back()
+		evicted := this.lru.Remove(oldest).(*cacheEntry)
+		delete(this.entries, evicted.key)
+		this.bytes -= evicted.bytes
+		this.stats.Evictions++
+	}
+	this.stats.Entries = len(this.entries)
+	this.stats.Bytes = this.bytes
+}
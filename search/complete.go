@@ -0,0 +1,165 @@
+// Autocomplete - the most frequent continuations of a prefix
+package search
+
+import (
+	"bytes"
+	"container/heap"
+
+	"github.com/mlinhard/exactly-index/esa"
+)
+
+type CompletionOptions struct {
+	MaxLength  int    // Maximum length of the continuation after the prefix, 0 means unlimited
+	Delimiters []byte // The continuation ends right before any of these bytes
+}
+
+type Completion struct {
+	Text      []byte // The prefix followed by the continuation
+	Count     int    // Number of occurrences of Text followed by a delimiter, document end or anything if MaxLength was reached
+	Documents int    // Number of documents containing these occurrences
+}
+
+type completionItem struct {
+	intervals []esa.Interval // More than one only for the completion merged from several children
+	size      int32
+	depth     int32 // Number of bytes of the suffixes known to be common and checked
+	done      bool
+}
+
+type completionHeap []*completionItem
+
+func (h completionHeap) Len() int {
+	return len(h)
+}
+
+func (h completionHeap) Less(i, j int) bool {
+	if h[i].size != h[j].size {
+		return h[i].size > h[j].size
+	}
+	return h[i].intervals[0].Start < h[j].intervals[0].Start
+}
+
+func (h completionHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+}
+
+func (h *completionHeap) Push(x interface{}) {
+	*h = append(*h, x.(*completionItem))
+}
+
+func (h *completionHeap) Pop() interface{} {
+	old := *h
+	r := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return r
+}
+
+type completer struct {
+	esa       *esa.EnhancedSuffixArray
+	prefixLen int32
+	options   *CompletionOptions
+	atEnd     func(pos int32) bool               // True iff the position is past the end of the document
+	documents func(intervals []esa.Interval) int // Number of distinct documents in the intervals
+}
+
+func (this *completer) maxLengthReached(depth int32) bool {
+	return this.options.MaxLength > 0 && depth-this.prefixLen >= int32(this.options.MaxLength)
+}
+
+// True iff continuation of the suffix starting at suffixStart ends at given depth
+func (this *completer) stopsAt(suffixStart int32, depth int32) bool {
+	if this.maxLengthReached(depth) {
+		return true
+	}
+	pos := suffixStart + depth
+	return this.atEnd(pos) || bytes.IndexByte(this.options.Delimiters, this.esa.Data[pos]) != -1
+}
+
+func (this *completer) completion(item *completionItem) Completion {
+	suffixStart := this.esa.SA[item.intervals[0].Start]
+	return Completion{
+		this.esa.Data[suffixStart : suffixStart+item.depth],
+		int(item.size),
+		this.documents(item.intervals)}
+}
+
+// Expands the item whose continuation didn't end yet. Children whose continuation ends
+// right at the branching point share the same text and are merged into one item.
+func (this *completer) expand(item *completionItem, queue *completionHeap) {
+	intv := item.intervals[0]
+	suffixStart := this.esa.SA[intv.Start]
+	node := this.esa.LcpInterval(intv.Start, intv.End)
+	for depth := item.depth; depth < node.Length; depth++ {
+		if this.stopsAt(suffixStart, depth) {
+			heap.Push(queue, &completionItem{item.intervals, item.size, depth, true})
+			return
+		}
+	}
+	if node.End-node.Start == 1 || this.maxLengthReached(node.Length) {
+		heap.Push(queue, &completionItem{item.intervals, item.size, node.Length, true})
+		return
+	}
+	stopped := &completionItem{nil, 0, node.Length, true}
+	this.esa.ForEachChild(node, func(child *esa.Interval) {
+		if this.stopsAt(this.esa.SA[child.Start], node.Length) {
+			stopped.intervals = append(stopped.intervals, *child)
+			stopped.size += child.End - child.Start
+		} else {
+			heap.Push(queue, &completionItem{[]esa.Interval{*child}, child.End - child.Start, node.Length, false})
+		}
+	})
+	if stopped.size > 0 {
+		heap.Push(queue, stopped)
+	}
+}
+
+// Explores the subtree of the interval of the prefix always expanding the largest interval
+// first, so the completions are found in order of decreasing count.
+func (this *completer) complete(interval *esa.Interval, k int) []Completion {
+	r := make([]Completion, 0)
+	if interval == nil {
+		return r
+	}
+	queue := &completionHeap{&completionItem{[]esa.Interval{*interval}, interval.End - interval.Start, this.prefixLen, false}}
+	for queue.Len() > 0 && len(r) < k {
+		item := heap.Pop(queue).(*completionItem)
+		if item.done {
+			r = append(r, this.completion(item))
+		} else {
+			this.expand(item, queue)
+		}
+	}
+	return r
+}
+
+// Returns at most k most frequent continuations of the prefix, the most frequent first. There
+// are none for the empty prefix, since every suffix of the text would continue it.
+func (search *SingleDocumentSearch) Complete(prefix []byte, k int, options CompletionOptions) []Completion {
+	dataLen := int32(len(search.esa.Data))
+	c := &completer{search.esa, int32(len(prefix)), &options,
+		func(pos int32) bool {
+			return pos >= dataLen
+		},
+		func(intervals []esa.Interval) int {
+			return 1
+		}}
+	if len(prefix) == 0 {
+		return make([]Completion, 0)
+	}
+	return c.complete(search.esa.Find(prefix, search.esa.Match), k)
+}
+
+// Returns at most k most frequent continuations of the prefix, the most frequent first. There
+// are none for the empty prefix, since every suffix of the text would continue it.
+func (search *MultiDocumentSearch) Complete(prefix []byte, k int, options CompletionOptions) []Completion {
+	dataLen := int32(len(search.esa.Data))
+	c := &completer{search.esa, int32(len(prefix)), &options,
+		func(pos int32) bool {
+			return pos >= dataLen || search.separatorAt(pos)
+		},
+		search.distinctDocuments}
+	if len(prefix) == 0 {
+		return make([]Completion, 0)
+	}
+	return c.complete(search.esa.Find(prefix, search.separatorAwareMatch), k)
+}
@@ -0,0 +1,194 @@
+// Distribution of the bytes following a pattern
+package search
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/mlinhard/exactly-index/esa"
+)
+
+type DistributionOptions struct {
+	ByDocument     bool // Count documents of each branch
+	ExtendToBranch bool // Extend each branch to the next branching point
+}
+
+type DistributionOption func(*DistributionOptions)
+
+func ByDocument() DistributionOption {
+	return func(options *DistributionOptions) {
+		options.ByDocument = true
+	}
+}
+
+func ExtendToBranch() DistributionOption {
+	return func(options *DistributionOptions) {
+		options.ExtendToBranch = true
+	}
+}
+
+// One branch of the right extensions of a pattern
+type NextByte struct {
+	Byte         byte   // The byte following the pattern
+	End          bool   // The pattern is followed by the end of the document, Byte is meaningless
+	Count        int    // Number of occurrences of the pattern followed by Byte
+	Documents    int    // Number of documents with these occurrences, computed only with ByDocument option
+	Continuation []byte // Bytes following the pattern common to all of these occurrences, only with ExtendToBranch option
+}
+
+type distributor struct {
+	esa       *esa.EnhancedSuffixArray
+	plen      int32
+	options   *DistributionOptions
+	atEnd     func(pos int32) bool
+	splitEnds func(intv esa.Interval, depth int32) ([]esa.Interval, []esa.Interval) // Separates suffixes ending at depth from the rest
+	documents func(intervals []esa.Interval) int
+}
+
+func intervalsSize(intervals []esa.Interval) int {
+	r := 0
+	for _, intv := range intervals {
+		r += int(intv.End - intv.Start)
+	}
+	return r
+}
+
+// Longest common prefix of the branch that doesn't cross the end of the document. The suffixes
+// are sorted, so it's the common prefix of the first and the last one.
+func (this *distributor) continuation(first, last int32) []byte {
+	a := this.esa.SA[first] + this.plen
+	b := this.esa.SA[last] + this.plen
+	n := int32(0)
+	for !this.atEnd(a+n) && !this.atEnd(b+n) && this.esa.Data[a+n] == this.esa.Data[b+n] {
+		n++
+	}
+	return this.esa.Data[a : a+n]
+}
+
+func (this *distributor) branch(intervals []esa.Interval) NextByte {
+	first := intervals[0].Start
+	last := intervals[len(intervals)-1].End - 1
+	r := NextByte{Byte: this.esa.Data[this.esa.SA[first]+this.plen], Count: intervalsSize(intervals)}
+	if this.options.ByDocument {
+		r.Documents = this.documents(intervals)
+	}
+	if this.options.ExtendToBranch {
+		r.Continuation = this.continuation(first, last)
+	}
+	return r
+}
+
+func (this *distributor) distribution(interval *esa.Interval) []NextByte {
+	r := make([]NextByte, 0)
+	if interval == nil {
+		return r
+	}
+	var branches []esa.Interval
+	node := this.esa.LcpInterval(interval.Start, interval.End)
+	if node.End-node.Start == 1 || node.Length > this.plen {
+		branches = append(branches, *interval)
+	} else {
+		this.esa.ForEachChild(node, func(child *esa.Interval) {
+			branches = append(branches, *child)
+		})
+	}
+	var allEnds []esa.Interval
+	for _, branch := range branches {
+		ends, rest := this.splitEnds(branch, this.plen)
+		allEnds = append(allEnds, ends...)
+		if len(rest) > 0 {
+			r = append(r, this.branch(rest))
+		}
+	}
+	if len(allEnds) > 0 {
+		end := NextByte{End: true, Count: intervalsSize(allEnds)}
+		if this.options.ByDocument {
+			end.Documents = this.documents(allEnds)
+		}
+		r = append([]NextByte{end}, r...)
+	}
+	return r
+}
+
+func newDistributionOptions(options []DistributionOption) *DistributionOptions {
+	r := new(DistributionOptions)
+	for _, option := range options {
+		option(r)
+	}
+	return r
+}
+
+// Splits the interval into the suffix ending at depth, if any, and the rest
+func (search *SingleDocumentSearch) splitEnds(intv esa.Interval, depth int32) ([]esa.Interval, []esa.Interval) {
+	if search.esa.SA[intv.Start]+depth < int32(len(search.esa.Data)) {
+		return nil, []esa.Interval{intv}
+	}
+	ends := []esa.Interval{{Length: intv.Length, Start: intv.Start, End: intv.Start + 1}}
+	if intv.Start+1 == intv.End {
+		return ends, nil
+	}
+	return ends, []esa.Interval{{Length: intv.Length, Start: intv.Start + 1, End: intv.End}}
+}
+
+// Returns the bytes following each occurrence of the pattern along with their frequencies.
+// These are the children of the lcp-interval of the pattern, in the order of the bytes,
+// the end of the document comes first.
+func (search *SingleDocumentSearch) NextByteDistribution(pattern []byte, options ...DistributionOption) []NextByte {
+	dataLen := int32(len(search.esa.Data))
+	d := &distributor{search.esa, int32(len(pattern)), newDistributionOptions(options),
+		func(pos int32) bool {
+			return pos >= dataLen
+		},
+		search.splitEnds,
+		func(intervals []esa.Interval) int {
+			return 1
+		}}
+	return d.distribution(search.esa.Find(pattern, search.esa.Match))
+}
+
+func appendNonEmpty(intervals []esa.Interval, length, start, end int32) []esa.Interval {
+	if start < end {
+		return append(intervals, esa.Interval{Length: length, Start: start, End: end})
+	}
+	return intervals
+}
+
+// Splits the interval into the suffixes followed by the separator or the end of the data
+// at depth and the rest. All suffixes in the interval share the same prefix up to depth,
+// so those followed by the separator form a continuous range found by binary search.
+func (search *MultiDocumentSearch) splitEnds(intv esa.Interval, depth int32) ([]esa.Interval, []esa.Interval) {
+	var ends, rest []esa.Interval
+	data := search.esa.Data
+	dataLen := int32(len(data))
+	start := intv.Start
+	if search.esa.SA[start]+depth >= dataLen {
+		ends = append(ends, esa.Interval{Length: intv.Length, Start: start, End: start + 1})
+		start++
+	}
+	compare := func(i int) int {
+		pos := search.esa.SA[i] + depth
+		end := pos + int32(len(search.separator))
+		return bytes.Compare(data[pos:ifelse(end > dataLen, dataLen, end)], search.separator)
+	}
+	n := int(intv.End - start)
+	sepStart := start + int32(sort.Search(n, func(i int) bool { return compare(int(start)+i) >= 0 }))
+	sepEnd := start + int32(sort.Search(n, func(i int) bool { return compare(int(start)+i) > 0 }))
+	ends = appendNonEmpty(ends, intv.Length, sepStart, sepEnd)
+	rest = appendNonEmpty(rest, intv.Length, start, sepStart)
+	rest = appendNonEmpty(rest, intv.Length, sepEnd, intv.End)
+	return ends, rest
+}
+
+// Returns the bytes following each occurrence of the pattern along with their frequencies.
+// These are the children of the lcp-interval of the pattern, in the order of the bytes,
+// the end of the document comes first.
+func (search *MultiDocumentSearch) NextByteDistribution(pattern []byte, options ...DistributionOption) []NextByte {
+	dataLen := int32(len(search.esa.Data))
+	d := &distributor{search.esa, int32(len(pattern)), newDistributionOptions(options),
+		func(pos int32) bool {
+			return pos >= dataLen || search.separatorAt(pos)
+		},
+		search.splitEnds,
+		search.distinctDocuments}
+	return d.distribution(search.esa.Find(pattern, search.separatorAwareMatch))
+}
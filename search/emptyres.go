@@ -12,7 +12,7 @@ func (this EmptySearchResult) Size() int {
 }
 
 func (this EmptySearchResult) Hit(i int) Hit {
-	panic("Empty search result has no hits")
+	panic(errNoHits)
 }
 
 func (this EmptySearchResult) PatternLength() int {
@@ -28,7 +28,7 @@ func (this EmptySearchResult) HasGlobalPosition(position int) bool {
 }
 
 func (this EmptySearchResult) HitWithGlobalPosition(position int) Hit {
-	panic("Empty search result has no hits")
+	return nil
 }
 
 func (this EmptySearchResult) HasPosition(document, position int) bool {
@@ -36,29 +36,77 @@ func (this EmptySearchResult) HasPosition(document, position int) bool {
 }
 
 func (this EmptySearchResult) HitWithPosition(document, position int) Hit {
-	panic("Empty search result has no hits")
+	return nil
 }
 
 func (this EmptySearchResult) Positions() []int {
 	return make([]int, 0)
 }
 
+func (this EmptySearchResult) Total() int {
+	return 0
+}
+
+func (this EmptySearchResult) ForEachHit(f func(hit Hit) bool) {
+}
+
+func (this EmptySearchResult) Page(offset, limit int) *HitPage {
+	if err := checkPage(offset, limit); err != nil {
+		panic(err)
+	}
+	return &HitPage{Hits: make([]Hit, 0)}
+}
+
+func (this EmptySearchResult) PageAfter(cursor string, limit int) (*HitPage, error) {
+	if err := checkPage(0, limit); err != nil {
+		return nil, err
+	}
+	if _, err := decodeCursor(cursor); err != nil {
+		return nil, err
+	}
+	return &HitPage{Hits: make([]Hit, 0)}, nil
+}
+
+func (this EmptySearchResult) Documents() []int {
+	return make([]int, 0)
+}
+
+func (this EmptySearchResult) DocumentHits(document int) []Hit {
+	return make([]Hit, 0)
+}
+
 func (this EmptySearchResult) document(hitIdx int) *Document {
-	panic("Empty search result has no hits")
+	panic(errNoHits)
+}
+
+func (this EmptySearchResult) documentIndex(hitIdx int) int {
+	panic(errNoHits)
 }
 
 func (this EmptySearchResult) position(hitIdx int) int {
-	panic("Empty search result has no hits")
+	panic(errNoHits)
 }
 
 func (this EmptySearchResult) globalPosition(hitIdx int) int {
-	panic("Empty search result has no hits")
+	panic(errNoHits)
 }
 
 func (this EmptySearchResult) charContext(hitIndex int, charsBefore, charsAfter int) HitContext {
-	panic("Empty search result has no hits")
+	panic(errNoHits)
 }
 
 func (this EmptySearchResult) lineContext(hitIndex int, linesBefore, linesAfter int) HitContext {
-	panic("Empty search result has no hits")
+	panic(errNoHits)
+}
+
+func (this EmptySearchResult) positionIndex() *positionIndex {
+	return new(positionIndex)
+}
+
+func (this EmptySearchResult) heldHits() int {
+	return 0
+}
+
+func (this EmptySearchResult) lineIndex(hitIndex int) *lineIndex {
+	panic(errNoHits)
 }
@@ -0,0 +1,109 @@
+// Errors of the search API
+package search
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/mlinhard/exactly-index/esa"
+)
+
+var (
+	ErrEmptyPattern    = esa.ErrEmptyPattern
+	ErrDocumentIndex   = errors.New("Document index out of range")
+	ErrHitIndex        = errors.New("Hit index out of range")
+	ErrNegativeContext = errors.New("Negative context length")
+	ErrNegativeLimit   = errors.New("Negative offset or limit")
+	ErrInvalidCursor   = errors.New("Invalid cursor")
+	ErrInvalidQuery    = errors.New("Invalid query")
+)
+
+var errNoHits = fmt.Errorf("%w: empty search result has no hits", ErrHitIndex)
+
+// Turns a panic into an error stored in err, must be called directly by a deferred call:
+//
+//	defer Recover(&err)
+func Recover(err *error) {
+	if r := recover(); r != nil {
+		if e, ok := r.(error); ok {
+			*err = e
+		} else {
+			*err = fmt.Errorf("%v", r)
+		}
+	}
+}
+
+// Find returning error instead of panicking on invalid pattern
+func TryFind(search Search, pattern []byte, options ...FindOption) (SearchResult, error) {
+	if len(pattern) == 0 {
+		return nil, ErrEmptyPattern
+	}
+	return search.Find(pattern, options...), nil
+}
+
+// FindAll returning error instead of panicking if any of the patterns is empty
+func TryFindAll(search BatchSearch, patterns [][]byte, options ...FindOption) ([]SearchResult, error) {
+	for i := range patterns {
+		if len(patterns[i]) == 0 {
+			return nil, fmt.Errorf("%w: pattern %v", ErrEmptyPattern, i)
+		}
+	}
+	return search.FindAll(patterns, options...), nil
+}
+
+// MaxHits returning error instead of panicking on negative limit
+func TryMaxHits(n int) (FindOption, error) {
+	if n < 0 {
+		return nil, fmt.Errorf("%w: hit limit %v", ErrNegativeLimit, n)
+	}
+	return MaxHits(n), nil
+}
+
+func TryDocument(search Search, idx int) (*Document, error) {
+	if err := checkDocumentIndex(idx, search.DocumentCount()); err != nil {
+		return nil, err
+	}
+	return search.Document(idx), nil
+}
+
+func checkDocumentIndex(idx, count int) error {
+	if idx < 0 || idx >= count {
+		return fmt.Errorf("%w: %v not in [0, %v)", ErrDocumentIndex, idx, count)
+	}
+	return nil
+}
+
+func TryHit(result SearchResult, idx int) (Hit, error) {
+	if idx < 0 || idx >= result.Size() {
+		return nil, fmt.Errorf("%w: %v exceeds the search result size %v", ErrHitIndex, idx, result.Size())
+	}
+	return result.Hit(idx), nil
+}
+
+func TryCharContext(hit Hit, charsBefore, charsAfter int) (HitContext, error) {
+	if charsBefore < 0 || charsAfter < 0 {
+		return nil, ErrNegativeContext
+	}
+	return hit.CharContext(charsBefore, charsAfter), nil
+}
+
+func TryLineContext(hit Hit, linesBefore, linesAfter int) (HitContext, error) {
+	if linesBefore < 0 || linesAfter < 0 {
+		return nil, ErrNegativeContext
+	}
+	return hit.LineContext(linesBefore, linesAfter), nil
+}
+
+func TryPage(result SearchResult, offset, limit int) (*HitPage, error) {
+	if err := checkPage(offset, limit); err != nil {
+		return nil, err
+	}
+	return result.Page(offset, limit), nil
+}
+
+func checkPage(offset, limit int) error {
+	if offset < 0 || limit < 0 {
+		return fmt.Errorf("%w: offset %v, limit %v", ErrNegativeLimit, offset, limit)
+	}
+	return nil
+}
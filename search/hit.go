@@ -1,5 +1,9 @@
 package search
 
+import (
+	"unicode/utf8"
+)
+
 type HitStruct struct {
 	searchResult SearchResult
 	hitIdx       int
@@ -11,6 +15,9 @@ type HitContextStruct struct {
 	lenBefore  int32
 	lenPattern int32
 	lenAfter   int32
+	result     SearchResult // resolves the line index of the document on demand, nil if not available
+	hitIdx     int          // hit of the result the context belongs to
+	offset     int32        // start of the document in data
 }
 
 func (this *HitStruct) GlobalPosition() int {
@@ -25,8 +32,39 @@ func (this *HitStruct) Document() *Document {
 	return this.searchResult.document(this.hitIdx)
 }
 
+func (this *HitStruct) StructuredContext(linesBefore, linesAfter int) *StructuredContext {
+	ctx := this.LineContext(linesBefore, linesAfter)
+	return structuredContext(this.searchResult, this.hitIdx, ctx)
+}
+
+func (this *HitStruct) BoundedLineContext(linesBefore, linesAfter, maxBytes int) HitContext {
+	return boundedLineContext(this.searchResult, this.hitIdx, linesBefore, linesAfter, maxBytes)
+}
+
+func (this *HitStruct) BoundedStructuredContext(linesBefore, linesAfter, maxBytes int) *StructuredContext {
+	ctx := boundedLineContext(this.searchResult, this.hitIdx, linesBefore, linesAfter, maxBytes)
+	return structuredContext(this.searchResult, this.hitIdx, ctx.HitContextStruct)
+}
+
+func (this *HitStruct) Line() int {
+	return this.searchResult.lineIndex(this.hitIdx).line(int32(this.Position()))
+}
+
+func (this *HitStruct) Column() int {
+	return this.searchResult.lineIndex(this.hitIdx).column(int32(this.Position()))
+}
+
+func (this *HitStruct) RuneColumn() int {
+	return this.searchResult.lineIndex(this.hitIdx).runeColumn(this.Document().Content, int32(this.Position()))
+}
+
 func (this *HitStruct) CharContext(charsBefore, charsAfter int) HitContext {
-	return this.searchResult.charContext(this.hitIdx, charsAfter, charsAfter)
+	return this.searchResult.charContext(this.hitIdx, charsBefore, charsAfter)
+}
+
+func (this *HitStruct) RuneContext(runesBefore, runesAfter int) HitContext {
+	ctx := this.searchResult.charContext(this.hitIdx, runesBefore*utf8.UTFMax, runesAfter*utf8.UTFMax)
+	return runeContext(ctx, runesBefore, runesAfter)
 }
 
 func (this *HitStruct) LineContext(linesBefore, linesAfter int) HitContext {
@@ -54,3 +92,70 @@ func (this *HitContextStruct) HighlightStart() int {
 func (this *HitContextStruct) HighlightEnd() int {
 	return int(this.lenBefore + this.lenPattern)
 }
+
+func (this *HitContextStruct) documentRange() (int32, int32) {
+	start := this.position - this.offset
+	return start, start + this.lenBefore + this.lenPattern + this.lenAfter
+}
+
+// Number of the line containing the position in data. The line index of the document is only
+// resolved here, so contexts used just for their content don't build it.
+func (this *HitContextStruct) lineAt(pos int32) int {
+	if this.result != nil {
+		return this.result.lineIndex(this.hitIdx).line(pos - this.offset)
+	}
+	r := 1
+	for i := this.offset; i < pos; i++ {
+		if isNewLine(this.data, i) > 0 {
+			r++
+		}
+	}
+	return r
+}
+
+func (this *HitContextStruct) StartLine() int {
+	return this.lineAt(this.position)
+}
+
+func (this *HitContextStruct) PatternLine() int {
+	return this.lineAt(this.position + this.lenBefore)
+}
+
+func (this *HitContextStruct) EndLine() int {
+	end := this.position + this.lenBefore + this.lenPattern + this.lenAfter
+	if end > this.position {
+		end--
+	}
+	return this.lineAt(end)
+}
+
+// Start of at most n runes preceding end but not preceding start. Bytes that are not part of
+// valid UTF-8 encoding count as one rune each.
+func runesBeforeStart(data []byte, start, end int32, n int) int32 {
+	for ; n > 0 && end > start; n-- {
+		_, size := utf8.DecodeLastRune(data[start:end])
+		end -= int32(size)
+	}
+	return end
+}
+
+// End of at most n runes following start but not following end
+func runesAfterEnd(data []byte, start, end int32, n int) int32 {
+	for ; n > 0 && start < end; n-- {
+		_, size := utf8.DecodeRune(data[start:end])
+		start += int32(size)
+	}
+	return start
+}
+
+// Shrinks the byte context to the given number of runes before and after the pattern. The
+// byte context must be long enough to contain them, e.g. utf8.UTFMax bytes per rune.
+func runeContext(ctx HitContext, runesBefore, runesAfter int) HitContext {
+	r := *ctx.(*HitContextStruct)
+	patternStart := r.position + r.lenBefore
+	patternEnd := patternStart + r.lenPattern
+	start := runesBeforeStart(r.data, r.position, patternStart, runesBefore)
+	end := runesAfterEnd(r.data, patternEnd, patternEnd+r.lenAfter, runesAfter)
+	r.position, r.lenBefore, r.lenAfter = start, patternStart-start, end-patternEnd
+	return &r
+}
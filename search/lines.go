@@ -0,0 +1,67 @@
+// Line and column numbers
+package search
+
+import (
+	"sort"
+	"sync"
+	"unicode/utf8"
+)
+
+// Positions of the line starts in a document. CR, LF and CRLF end a line.
+type lineIndex struct {
+	starts []int32 // starts[i] is the position of the first byte of the line i+1
+}
+
+// Line indexes of the documents of a search, built on first use, safe for concurrent use
+type lineIndexes struct {
+	indexes []*lineIndex
+	built   []sync.Once
+}
+
+// Length of the line break starting at position i of data, 0 if there's none. CR, LF and CRLF
+// are line breaks, the LF of CRLF doesn't start one.
+func LineBreak(data []byte, i int) int {
+	return int(isNewLine(data, int32(i)))
+}
+
+func newLineIndex(content []byte) *lineIndex {
+	starts := []int32{0}
+	for i := int32(0); i < int32(len(content)); i++ {
+		if n := isNewLine(content, i); n > 0 {
+			starts = append(starts, i+n)
+			i += n - 1
+		}
+	}
+	return &lineIndex{starts}
+}
+
+// Number of the line containing the position, the first line is 1
+func (this *lineIndex) line(pos int32) int {
+	return sort.Search(len(this.starts), func(i int) bool { return this.starts[i] > pos })
+}
+
+// Position of the first byte of the line
+func (this *lineIndex) lineStart(line int) int32 {
+	return this.starts[line-1]
+}
+
+// Byte column of the position, the first column is 1
+func (this *lineIndex) column(pos int32) int {
+	return int(pos-this.lineStart(this.line(pos))) + 1
+}
+
+// Column of the position counted in UTF-8 runes of the content, the first column is 1
+func (this *lineIndex) runeColumn(content []byte, pos int32) int {
+	return utf8.RuneCount(content[this.lineStart(this.line(pos)):pos]) + 1
+}
+
+func newLineIndexes(documentCount int) *lineIndexes {
+	return &lineIndexes{make([]*lineIndex, documentCount), make([]sync.Once, documentCount)}
+}
+
+func (this *lineIndexes) get(document *Document) *lineIndex {
+	this.built[document.Index].Do(func() {
+		this.indexes[document.Index] = newLineIndex(document.Content)
+	})
+	return this.indexes[document.Index]
+}
@@ -3,6 +3,7 @@ package search
 import (
 	"fmt"
 	"sort"
+	"sync/atomic"
 
 	"github.com/mlinhard/exactly-index/esa"
 )
@@ -13,12 +14,14 @@ type MultiDocumentSearch struct {
 	ids                []string
 	separator          []byte
 	newLineInSeparator int32
+	lines              *lineIndexes
 }
 
 type MultiDocumentSearchResult struct {
-	MultiDocumentSearch
+	*MultiDocumentSearch
 	interval      esa.Interval
-	docIndexCache []int32
+	docIndexCache []int32 // accessed atomically, esa.UNDEF until computed
+	posIndex      lazyPositionIndex
 }
 
 func toint32(a []int) []int32 {
@@ -29,7 +32,24 @@ func toint32(a []int) []int32 {
 	return r
 }
 
-func NewMulti(combinedContent []byte, offsets []int, docIds []string) (*MultiDocumentSearch, error) {
+type IndexOptions struct {
+	DocumentArray bool // Build document array for fast document listing, enabled by default
+}
+
+type IndexOption func(*IndexOptions)
+
+// Saves the memory of the document array at the cost of slower document queries
+func WithoutDocumentArray() IndexOption {
+	return func(options *IndexOptions) {
+		options.DocumentArray = false
+	}
+}
+
+func NewMulti(combinedContent []byte, offsets []int, docIds []string, options ...IndexOption) (*MultiDocumentSearch, error) {
+	indexOptions := &IndexOptions{DocumentArray: true}
+	for _, option := range options {
+		option(indexOptions)
+	}
 	search := new(MultiDocumentSearch)
 	search.ids = docIds
 	search.offsets = toint32(offsets)
@@ -37,9 +57,13 @@ func NewMulti(combinedContent []byte, offsets []int, docIds []string) (*MultiDoc
 	if err != nil {
 		return nil, err
 	}
+	if indexOptions.DocumentArray {
+		esa.ComputeDocumentArray(search.offsets)
+	}
 	search.separator = separator
 	search.esa = esa
 	search.newLineInSeparator = newLineInSeparator(separator)
+	search.lines = newLineIndexes(len(docIds))
 	return search, nil
 }
 
@@ -80,19 +104,58 @@ func (this *MultiDocumentSearch) separatorAwareMatch(pattern []byte, dataOff int
 	return true
 }
 
-func (search *MultiDocumentSearch) Find(pattern []byte) SearchResult {
+func (search *MultiDocumentSearch) Find(pattern []byte, options ...FindOption) SearchResult {
+	findOptions := newFindOptions(options)
+	if findOptions.IgnoreCase {
+		intervals := search.esa.FindFold(pattern, search.separatorAwareMatch)
+		results := make([]SearchResult, len(intervals))
+		for i := range intervals {
+			results[i] = search.newResult(pattern, intervals[i], new(FindOptions))
+		}
+		return findOptions.filter(newUnionSearchResult(pattern, results))
+	}
 	interval := search.esa.Find(pattern, search.separatorAwareMatch)
+	return search.newResult(pattern, interval, findOptions)
+}
+
+func (search *MultiDocumentSearch) countHits(pattern []byte, options *FindOptions) int {
+	if options.IgnoreCase {
+		return foundSize(search.esa.FindFold(pattern, search.separatorAwareMatch))
+	}
+	return foundSize([]*esa.Interval{search.esa.Find(pattern, search.separatorAwareMatch)})
+}
+
+// Case insensitive patterns are looked up one by one, each of them in all of its case variants
+func (search *MultiDocumentSearch) FindAll(patterns [][]byte, options ...FindOption) []SearchResult {
+	findOptions := newFindOptions(options)
+	r := make([]SearchResult, len(patterns))
+	if findOptions.IgnoreCase {
+		for i := range r {
+			r[i] = search.Find(patterns[i], options...)
+		}
+		return r
+	}
+	intervals := search.esa.FindAll(patterns, search.separatorAwareMatch)
+	for i := range r {
+		r[i] = search.newResult(patterns[i], intervals[i], findOptions)
+	}
+	return r
+}
+
+func (search *MultiDocumentSearch) newResult(pattern []byte, interval *esa.Interval, options *FindOptions) SearchResult {
 	if interval == nil {
 		return EmptySearchResult(pattern)
 	}
 	sr := new(MultiDocumentSearchResult)
 	sr.interval = *interval
-	sr.MultiDocumentSearch = *search
-	sr.docIndexCache = make([]int32, sr.interval.End-sr.interval.Start)
-	for i := range sr.docIndexCache {
-		sr.docIndexCache[i] = esa.UNDEF
+	sr.MultiDocumentSearch = search
+	if search.esa.Documents == nil {
+		sr.docIndexCache = make([]int32, sr.interval.End-sr.interval.Start)
+		for i := range sr.docIndexCache {
+			sr.docIndexCache[i] = esa.UNDEF
+		}
 	}
-	return sr
+	return options.filter(sr)
 }
 
 func (search *MultiDocumentSearch) DocumentCount() int {
@@ -100,6 +163,9 @@ func (search *MultiDocumentSearch) DocumentCount() int {
 }
 
 func (search *MultiDocumentSearch) Document(idx int) *Document {
+	if err := checkDocumentIndex(idx, len(search.offsets)); err != nil {
+		panic(err)
+	}
 	start := search.offsets[idx]
 	end := int32(len(search.esa.Data))
 	if idx < len(search.offsets)-1 {
@@ -120,11 +186,16 @@ func (this *MultiDocumentSearchResult) Size() int {
 	return int(this.interval.End - this.interval.Start)
 }
 
-func (this *MultiDocumentSearchResult) globalPosition(hitIdx int) int {
+// Index of the hit in the suffix array
+func (this *MultiDocumentSearchResult) saIndex(hitIdx int) int32 {
 	if hitIdx < 0 || int32(hitIdx) >= int32(this.Size()) {
-		panic(fmt.Sprintf("Hit index %v exceeds the search result size %v", hitIdx, this.Size()))
+		panic(fmt.Errorf("%w: %v exceeds the search result size %v", ErrHitIndex, hitIdx, this.Size()))
 	}
-	return int(this.esa.SA[this.interval.Start+int32(hitIdx)])
+	return this.interval.Start + int32(hitIdx)
+}
+
+func (this *MultiDocumentSearchResult) globalPosition(hitIdx int) int {
+	return int(this.esa.SA[this.saIndex(hitIdx)])
 }
 
 func (this *MultiDocumentSearchResult) position(hitIdx int) int {
@@ -135,17 +206,40 @@ func Search32(a []int32, n int32) int32 {
 	return int32(sort.Search(len(a), func(i int) bool { return a[i] > n }))
 }
 
+// Number of distinct documents containing the suffixes of the intervals
+func (this *MultiDocumentSearch) distinctDocuments(intervals []esa.Interval) int {
+	if len(intervals) == 1 && this.esa.Documents != nil {
+		return this.esa.Documents.CountDistinct(intervals[0].Start, intervals[0].End)
+	}
+	docs := make(map[int32]bool)
+	for _, intv := range intervals {
+		if this.esa.Documents != nil {
+			this.esa.Documents.ForEachDistinct(intv.Start, intv.End, func(doc int32) {
+				docs[doc] = true
+			})
+			continue
+		}
+		for i := intv.Start; i < intv.End; i++ {
+			docs[Search32(this.offsets, this.esa.SA[i])-1] = true
+		}
+	}
+	return len(docs)
+}
+
 func (this *MultiDocumentSearchResult) document(hitIdx int) *Document {
 	return this.Document(this.documentIndex(hitIdx))
 }
 
 func (this *MultiDocumentSearchResult) documentIndex(hitIdx int) int {
-	if this.docIndexCache[hitIdx] == esa.UNDEF {
-		pos := int32(this.globalPosition(hitIdx))
-		r := Search32(this.offsets, pos)
-		this.docIndexCache[hitIdx] = r - 1
+	if this.esa.Documents != nil {
+		return int(this.esa.Documents.DA[this.saIndex(hitIdx)])
 	}
-	return int(this.docIndexCache[hitIdx])
+	r := atomic.LoadInt32(&this.docIndexCache[hitIdx])
+	if r == esa.UNDEF {
+		r = Search32(this.offsets, int32(this.globalPosition(hitIdx))) - 1
+		atomic.StoreInt32(&this.docIndexCache[hitIdx], r)
+	}
+	return int(r)
 }
 
 func (this *MultiDocumentSearchResult) Hit(hitIdx int) Hit {
@@ -161,20 +255,28 @@ func (this *MultiDocumentSearchResult) Pattern() []byte {
 	return this.esa.Data[patternStart : patternStart+this.interval.Length]
 }
 
+func (this *MultiDocumentSearchResult) positionIndex() *positionIndex {
+	return this.posIndex.get(this)
+}
+
+func (this *MultiDocumentSearchResult) heldHits() int {
+	return this.Size()
+}
+
 func (this *MultiDocumentSearchResult) HasGlobalPosition(position int) bool {
-	return false
+	return this.positionIndex().findGlobal(this, position) != -1
 }
 
 func (this *MultiDocumentSearchResult) HitWithGlobalPosition(position int) Hit {
-	return nil
+	return hitOrNil(this, this.positionIndex().findGlobal(this, position))
 }
 
 func (this *MultiDocumentSearchResult) HasPosition(document, position int) bool {
-	return false
+	return this.positionIndex().find(this, document, position) != -1
 }
 
 func (this *MultiDocumentSearchResult) HitWithPosition(document, position int) Hit {
-	return nil
+	return hitOrNil(this, this.positionIndex().find(this, document, position))
 }
 
 func (this *MultiDocumentSearchResult) Positions() []int {
@@ -185,9 +287,71 @@ func (this *MultiDocumentSearchResult) Positions() []int {
 	return r
 }
 
+func (this *MultiDocumentSearchResult) Total() int {
+	return this.Size()
+}
+
+func (this *MultiDocumentSearchResult) ForEachHit(f func(hit Hit) bool) {
+	forEachHit(this, f)
+}
+
+func (this *MultiDocumentSearchResult) Page(offset, limit int) *HitPage {
+	return page(this, offset, limit)
+}
+
+func (this *MultiDocumentSearchResult) PageAfter(cursor string, limit int) (*HitPage, error) {
+	return pageAfter(this, cursor, limit)
+}
+
+// With document array the documents are listed in time proportional to their number
+func (this *MultiDocumentSearchResult) Documents() []int {
+	if this.esa.Documents == nil {
+		return this.positionIndex().distinctDocuments(this)
+	}
+	docs := this.esa.Documents.Distinct(this.interval.Start, this.interval.End)
+	r := make([]int, len(docs))
+	for i := range docs {
+		r[i] = int(docs[i])
+	}
+	return r
+}
+
+// Number of hits in the document, requires document array
+func (this *MultiDocumentSearchResult) documentHitCount(document int) int {
+	return this.esa.Documents.Count(this.interval.Start, this.interval.End, int32(document))
+}
+
+// Indexes of the hits in the document sorted by position, requires document array. Only the
+// hits of the document are visited.
+func (this *MultiDocumentSearchResult) documentHitIndexes(document int) []int32 {
+	r := make([]int32, 0)
+	this.esa.Documents.ForEachSuffix(this.interval.Start, this.interval.End, int32(document), func(i int32) {
+		r = append(r, i-this.interval.Start)
+	})
+	sa := this.esa.SA[this.interval.Start:this.interval.End]
+	sort.Slice(r, func(a, b int) bool { return sa[r[a]] < sa[r[b]] })
+	return r
+}
+
+// With document array only the hits of the document are visited
+func (this *MultiDocumentSearchResult) DocumentHits(document int) []Hit {
+	if this.esa.Documents == nil {
+		return this.positionIndex().documentHits(this, document)
+	}
+	if document < 0 || document >= len(this.offsets) {
+		return make([]Hit, 0)
+	}
+	hits := this.documentHitIndexes(document)
+	r := make([]Hit, len(hits))
+	for i := range hits {
+		r[i] = this.Hit(int(hits[i]))
+	}
+	return r
+}
+
 func (this *MultiDocumentSearchResult) charContext(hitIndex int, charsBefore, charsAfter int) HitContext {
 	if charsBefore < 0 || charsAfter < 0 {
-		panic("Negative context length")
+		panic(ErrNegativeContext)
 	}
 	pos := int32(this.globalPosition(hitIndex))
 	beforeStart := this.checkBefore(pos, int32(charsBefore))
@@ -197,12 +361,15 @@ func (this *MultiDocumentSearchResult) charContext(hitIndex int, charsBefore, ch
 		beforeStart,
 		pos - beforeStart,
 		this.interval.Length,
-		afterEnd - pos - this.interval.Length}
+		afterEnd - pos - this.interval.Length,
+		this,
+		hitIndex,
+		this.offsets[this.documentIndex(hitIndex)]}
 }
 
 func (this *MultiDocumentSearchResult) lineContext(hitIndex int, linesBefore, linesAfter int) HitContext {
 	if linesBefore < 0 || linesAfter < 0 {
-		panic("Negative context length")
+		panic(ErrNegativeContext)
 	}
 	patternStart := int32(this.globalPosition(hitIndex))
 	beforeStart := this.linesBeforeStart(hitIndex, linesBefore)
@@ -212,7 +379,14 @@ func (this *MultiDocumentSearchResult) lineContext(hitIndex int, linesBefore, li
 		beforeStart,
 		patternStart - beforeStart,
 		this.interval.Length,
-		afterEnd - patternStart - this.interval.Length}
+		afterEnd - patternStart - this.interval.Length,
+		this,
+		hitIndex,
+		this.offsets[this.documentIndex(hitIndex)]}
+}
+
+func (this *MultiDocumentSearchResult) lineIndex(hitIndex int) *lineIndex {
+	return this.lines.get(this.document(hitIndex))
 }
 
 func (this *MultiDocumentSearchResult) checkBefore(pos int32, maxSize int32) int32 {
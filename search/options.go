@@ -0,0 +1,138 @@
+// Query options of Search.Find
+package search
+
+import (
+	"fmt"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Restrictions on the surroundings of a hit, can be combined
+type MatchFlags int
+
+const (
+	WholeWord MatchFlags = 1 << iota // Neither the character before nor after the hit is a word character
+	LineStart                        // The hit starts at the beginning of a line
+	LineEnd                          // The hit ends at the end of a line
+	WholeLine = LineStart | LineEnd
+)
+
+// Set of characters considered part of a word by WholeWord matching
+type WordChars int
+
+const (
+	ASCIIWordChars   WordChars = iota // ASCII letters, digits and underscore
+	UnicodeWordChars                  // Unicode letters, digits and underscore in UTF-8 encoding
+)
+
+type FindOptions struct {
+	Match      MatchFlags
+	WordChars  WordChars
+	IgnoreCase bool // ASCII letters match regardless of their case
+	MaxHits    int  // Maximum number of hits of the result taken in hit index order, 0 for no limit
+}
+
+type FindOption func(*FindOptions)
+
+// Restricts hits to those satisfying all of the given flags
+func Match(flags MatchFlags) FindOption {
+	return func(options *FindOptions) {
+		options.Match |= flags
+	}
+}
+
+func WithWordChars(wordChars WordChars) FindOption {
+	return func(options *FindOptions) {
+		options.WordChars = wordChars
+	}
+}
+
+func IgnoreCase(ignoreCase bool) FindOption {
+	return func(options *FindOptions) {
+		options.IgnoreCase = ignoreCase
+	}
+}
+
+// Limits the result to the n hits with the smallest hit indexes. For a plain pattern that's the
+// suffix array order, i.e. the lexicographic order of the text following the hits, not the order
+// of their positions. The exact number of all hits stays available via SearchResult.Total, it's
+// known from the index without visiting them unless a match restriction needs to inspect each hit.
+func MaxHits(n int) FindOption {
+	if n < 0 {
+		panic(fmt.Errorf("%w: hit limit %v", ErrNegativeLimit, n))
+	}
+	return func(options *FindOptions) {
+		options.MaxHits = n
+	}
+}
+
+func newFindOptions(options []FindOption) *FindOptions {
+	r := new(FindOptions)
+	for _, option := range options {
+		option(r)
+	}
+	return r
+}
+
+func isASCIIWordChar(c rune) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c == '_'
+}
+
+func isUnicodeWordChar(c rune) bool {
+	return unicode.IsLetter(c) || unicode.IsDigit(c) || c == '_'
+}
+
+func (this *FindOptions) isWordChar(c rune) bool {
+	if this.WordChars == UnicodeWordChars {
+		return c != utf8.RuneError && isUnicodeWordChar(c)
+	}
+	return isASCIIWordChar(c)
+}
+
+func isNewLineChar(c byte) bool {
+	return c == 10 || c == 13
+}
+
+func (this *FindOptions) accept(ctx HitContext) bool {
+	before := ctx.Before()
+	after := ctx.After()
+	if this.Match&WholeWord != 0 {
+		if len(before) > 0 {
+			c, _ := utf8.DecodeLastRune(before)
+			if this.isWordChar(c) {
+				return false
+			}
+		}
+		if len(after) > 0 {
+			c, _ := utf8.DecodeRune(after)
+			if this.isWordChar(c) {
+				return false
+			}
+		}
+	}
+	if this.Match&LineStart != 0 && len(before) > 0 && !isNewLineChar(before[len(before)-1]) {
+		return false
+	}
+	if this.Match&LineEnd != 0 && len(after) > 0 && !isNewLineChar(after[0]) {
+		return false
+	}
+	return true
+}
+
+// Applies the match restrictions and the hit limit to the search result. The surroundings of
+// each hit are inspected via its character context that never crosses the document boundary.
+func (this *FindOptions) filter(result SearchResult) SearchResult {
+	if this.Match != 0 && !result.IsEmpty() {
+		result = newSubsetSearchResult(result, func(hitIdx int) bool {
+			return this.accept(result.charContext(hitIdx, utf8.UTFMax, utf8.UTFMax))
+		})
+	}
+	return this.limit(result)
+}
+
+func (this *FindOptions) limit(result SearchResult) SearchResult {
+	if this.MaxHits == 0 || result.Size() <= this.MaxHits {
+		return result
+	}
+	return newLimitedSearchResult(result, this.MaxHits)
+}
@@ -0,0 +1,195 @@
+// Iteration over hits in document order
+package search
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Page of hits sorted by document and position in the document
+type HitPage struct {
+	Hits   []Hit
+	Cursor string // Resumes the iteration right after the last hit of this page, empty if there are no more hits
+}
+
+// Iterates hits of a search result sorted by document and position in the document in time
+// linear in their number. With document array only the hits of the current document are kept in
+// memory, otherwise the position index of the result with 4 bytes per hit is built on first use.
+type HitIterator struct {
+	walk    *hitWalk
+	current int32 // index of the current hit
+}
+
+// Walks hits of a search result in document order. Results with document array are walked one
+// document at a time, sorting only the hits of the current document. Other results are walked
+// along their position index, which is built on first use and shared by all walks of the result.
+type hitWalk struct {
+	result    SearchResult
+	hits      []int32 // hit indexes sorted by global position, hits[next:] are not walked yet
+	next      int
+	multi     *MultiDocumentSearchResult // the result if it has document array, nil otherwise
+	documents []int32                    // documents to walk after the hits in ascending order
+}
+
+func newHitWalk(result SearchResult) *hitWalk {
+	if multi, ok := result.(*MultiDocumentSearchResult); ok && multi.esa.Documents != nil {
+		documents := multi.esa.Documents.Distinct(multi.interval.Start, multi.interval.End)
+		return &hitWalk{result: result, multi: multi, documents: documents}
+	}
+	return &hitWalk{result: result, hits: result.positionIndex().hits}
+}
+
+// Loads the hits of the next document once the current ones are walked, false if there are no more
+func (this *hitWalk) hasNext() bool {
+	for this.next == len(this.hits) {
+		if len(this.documents) == 0 {
+			return false
+		}
+		this.hits, this.next = this.multi.documentHitIndexes(int(this.documents[0])), 0
+		this.documents = this.documents[1:]
+	}
+	return true
+}
+
+func (this *hitWalk) pop() int32 {
+	this.hasNext()
+	this.next++
+	return this.hits[this.next-1]
+}
+
+// Skips n hits, whole documents are skipped by their number of hits without loading them
+func (this *hitWalk) skip(n int) {
+	for n > 0 {
+		if remaining := len(this.hits) - this.next; remaining > 0 {
+			if remaining > n {
+				remaining = n
+			}
+			this.next += remaining
+			n -= remaining
+		} else if len(this.documents) == 0 {
+			return
+		} else if count := this.multi.documentHitCount(int(this.documents[0])); count <= n {
+			this.documents = this.documents[1:]
+			n -= count
+		} else {
+			this.hasNext()
+		}
+	}
+}
+
+// Skips the hits with global position less than or equal to after
+func (this *hitWalk) seek(after int) {
+	if this.multi != nil {
+		document := Search32(this.multi.offsets, int32(after)) - 1
+		i := sort.Search(len(this.documents), func(i int) bool { return this.documents[i] >= document })
+		this.documents = this.documents[i:]
+		this.hasNext()
+	}
+	hits := this.hits[this.next:]
+	this.next += sort.Search(len(hits), func(i int) bool {
+		return this.result.globalPosition(int(hits[i])) > after
+	})
+}
+
+// Page of at most limit next hits
+func (this *hitWalk) page(limit int) *HitPage {
+	hits := make([]int32, 0)
+	for len(hits) < limit && this.hasNext() {
+		hits = append(hits, this.pop())
+	}
+	return newHitPage(this.result, hits, this.hasNext())
+}
+
+// Streams the hits without allocating memory proportional to the size of the result, though
+// result.Hit allocates a HitStruct for each visited hit
+func forEachHit(result SearchResult, f func(hit Hit) bool) {
+	for i := 0; i < result.Size(); i++ {
+		if !f(result.Hit(i)) {
+			return
+		}
+	}
+}
+
+func encodeCursor(globalPosition int) string {
+	return "h" + strconv.FormatInt(int64(globalPosition), 36)
+}
+
+func decodeCursor(cursor string) (int, error) {
+	if !strings.HasPrefix(cursor, "h") {
+		return 0, fmt.Errorf("%w %q", ErrInvalidCursor, cursor)
+	}
+	r, err := strconv.ParseInt(cursor[1:], 36, 64)
+	if err != nil || r < 0 {
+		return 0, fmt.Errorf("%w %q", ErrInvalidCursor, cursor)
+	}
+	return int(r), nil
+}
+
+func NewHitIterator(result SearchResult) *HitIterator {
+	return &HitIterator{walk: newHitWalk(result), current: -1}
+}
+
+// Creates iterator continuing after the hit the cursor was obtained for
+func ResumeHitIterator(result SearchResult, cursor string) (*HitIterator, error) {
+	last, err := decodeCursor(cursor)
+	if err != nil {
+		return nil, err
+	}
+	walk := newHitWalk(result)
+	walk.seek(last)
+	return &HitIterator{walk: walk, current: -1}, nil
+}
+
+// Advances to the next hit, returns false if there are no more hits
+func (this *HitIterator) Next() bool {
+	if !this.walk.hasNext() {
+		return false
+	}
+	this.current = this.walk.pop()
+	return true
+}
+
+// The current hit
+func (this *HitIterator) Hit() Hit {
+	return this.walk.result.Hit(int(this.current))
+}
+
+// Cursor to resume the iteration after the current hit
+func (this *HitIterator) Cursor() string {
+	return encodeCursor(this.walk.result.globalPosition(int(this.current)))
+}
+
+func newHitPage(result SearchResult, hits []int32, hasMore bool) *HitPage {
+	page := &HitPage{Hits: make([]Hit, len(hits))}
+	for i := range hits {
+		page.Hits[i] = result.Hit(int(hits[i]))
+	}
+	if hasMore && len(hits) > 0 {
+		page.Cursor = encodeCursor(result.globalPosition(int(hits[len(hits)-1])))
+	}
+	return page
+}
+
+func page(result SearchResult, offset, limit int) *HitPage {
+	if err := checkPage(offset, limit); err != nil {
+		panic(err)
+	}
+	walk := newHitWalk(result)
+	walk.skip(offset)
+	return walk.page(limit)
+}
+
+func pageAfter(result SearchResult, cursor string, limit int) (*HitPage, error) {
+	if err := checkPage(0, limit); err != nil {
+		return nil, err
+	}
+	last, err := decodeCursor(cursor)
+	if err != nil {
+		return nil, err
+	}
+	walk := newHitWalk(result)
+	walk.seek(last)
+	return walk.page(limit), nil
+}
@@ -0,0 +1,182 @@
+// Lookup of hits by their position
+package search
+
+import (
+	"sort"
+	"sync"
+)
+
+// Hit indexes of a search result sorted by global position. Since the documents follow each
+// other in the combined text, this is also the order by document and position in it.
+type positionIndex struct {
+	hits      []int32
+	documents []int   // indexes of the documents with hits in ascending order, computed lazily
+	docStarts []int32 // hits of documents[i] are hits[docStarts[i]:docStarts[i+1]]
+	grouped   sync.Once
+}
+
+// Position index of a search result built on first use by any of the goroutines sharing the result
+type lazyPositionIndex struct {
+	once  sync.Once
+	index *positionIndex
+}
+
+func (this *lazyPositionIndex) get(result SearchResult) *positionIndex {
+	this.once.Do(func() {
+		this.index = newPositionIndex(result)
+	})
+	return this.index
+}
+
+// Results smaller than this are sorted by comparison
+const radixSortMinSize = 64
+
+// Number of values of one radix sort digit
+const radixDigits = 1 << 8
+
+// Sorts the hits by global position in place, so that the sorted hit indexes are the only memory
+// proportional to the size of the result
+func newPositionIndex(result SearchResult) *positionIndex {
+	hits := make([]int32, result.Size())
+	for i := range hits {
+		hits[i] = int32(i)
+	}
+	sortByKey(hits, func(hit int32) int32 {
+		return int32(result.globalPosition(int(hit)))
+	})
+	return &positionIndex{hits: hits}
+}
+
+// Sorts the hits by their non-negative keys. Large slices are sorted in place by radix sort with
+// 8-bit digits, most significant first, starting with the highest digit of the largest key. The
+// keys are computed on the fly a constant number of times per hit.
+func sortByKey(hits []int32, key func(hit int32) int32) {
+	if len(hits) < radixSortMinSize {
+		sortByComparison(hits, key)
+		return
+	}
+	max := int32(0)
+	for _, hit := range hits {
+		if k := key(hit); k > max {
+			max = k
+		}
+	}
+	shift := uint(0)
+	for shift < 24 && max>>(shift+8) != 0 {
+		shift += 8
+	}
+	sortByDigit(hits, key, shift)
+}
+
+// Distributes the hits into buckets by the digit at shift, swapping each misplaced hit into the
+// next free slot of its bucket, then sorts the buckets by the lower digits
+func sortByDigit(hits []int32, key func(hit int32) int32, shift uint) {
+	if len(hits) < radixSortMinSize {
+		sortByComparison(hits, key)
+		return
+	}
+	digit := func(hit int32) int {
+		return int(key(hit) >> shift & (radixDigits - 1))
+	}
+	var starts [radixDigits + 1]int // hits with digit d end up in [starts[d], starts[d+1])
+	var next [radixDigits]int       // next unsorted slot of each bucket
+	for _, hit := range hits {
+		starts[digit(hit)+1]++
+	}
+	for i := 1; i < len(starts); i++ {
+		starts[i] += starts[i-1]
+	}
+	copy(next[:], starts[:])
+	for d := range next {
+		for next[d] < starts[d+1] {
+			hit := hits[next[d]]
+			for hd := digit(hit); hd != d; hd = digit(hit) {
+				hit, hits[next[hd]] = hits[next[hd]], hit
+				next[hd]++
+			}
+			hits[next[d]] = hit
+			next[d]++
+		}
+	}
+	if shift == 0 {
+		return
+	}
+	for d := 0; d < radixDigits; d++ {
+		sortByDigit(hits[starts[d]:starts[d+1]], key, shift-8)
+	}
+}
+
+func sortByComparison(hits []int32, key func(hit int32) int32) {
+	sort.Slice(hits, func(a, b int) bool { return key(hits[a]) < key(hits[b]) })
+}
+
+// Groups the hits by document, hits of one document are adjacent in the index.
+func (this *positionIndex) groupByDocument(result SearchResult) {
+	this.grouped.Do(func() {
+		this.documents = make([]int, 0)
+		this.docStarts = make([]int32, 0)
+		for i := range this.hits {
+			document := result.documentIndex(int(this.hits[i]))
+			if len(this.documents) == 0 || this.documents[len(this.documents)-1] != document {
+				this.documents = append(this.documents, document)
+				this.docStarts = append(this.docStarts, int32(i))
+			}
+		}
+		this.docStarts = append(this.docStarts, int32(len(this.hits)))
+	})
+}
+
+func (this *positionIndex) distinctDocuments(result SearchResult) []int {
+	this.groupByDocument(result)
+	r := make([]int, len(this.documents))
+	copy(r, this.documents)
+	return r
+}
+
+func (this *positionIndex) documentHits(result SearchResult, document int) []Hit {
+	this.groupByDocument(result)
+	i := sort.SearchInts(this.documents, document)
+	if i == len(this.documents) || this.documents[i] != document {
+		return make([]Hit, 0)
+	}
+	hits := this.hits[this.docStarts[i]:this.docStarts[i+1]]
+	r := make([]Hit, len(hits))
+	for j := range hits {
+		r[j] = result.Hit(int(hits[j]))
+	}
+	return r
+}
+
+// Returns index of the hit with given global position or -1 if there's none
+func (this *positionIndex) findGlobal(result SearchResult, position int) int {
+	i := sort.Search(len(this.hits), func(i int) bool {
+		return result.globalPosition(int(this.hits[i])) >= position
+	})
+	if i < len(this.hits) && result.globalPosition(int(this.hits[i])) == position {
+		return int(this.hits[i])
+	}
+	return -1
+}
+
+// Returns index of the hit with given position in the document or -1 if there's none
+func (this *positionIndex) find(result SearchResult, document, position int) int {
+	i := sort.Search(len(this.hits), func(i int) bool {
+		hitIdx := int(this.hits[i])
+		hitDocument := result.documentIndex(hitIdx)
+		return hitDocument > document || (hitDocument == document && result.position(hitIdx) >= position)
+	})
+	if i < len(this.hits) {
+		hitIdx := int(this.hits[i])
+		if result.documentIndex(hitIdx) == document && result.position(hitIdx) == position {
+			return hitIdx
+		}
+	}
+	return -1
+}
+
+func hitOrNil(result SearchResult, hitIdx int) Hit {
+	if hitIdx == -1 {
+		return nil
+	}
+	return result.Hit(hitIdx)
+}
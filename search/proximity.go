@@ -0,0 +1,175 @@
+// Proximity and ordered sequence queries
+package search
+
+import (
+	"unicode/utf8"
+)
+
+// Limit of the distance between two hits
+type Distance struct {
+	Bytes int // Maximum number of bytes between the end of the first hit and the start of the second one, negative for no limit
+	Lines int // Maximum number of line breaks between the hits, negative for no limit
+}
+
+func WithinBytes(n int) Distance {
+	return Distance{n, -1}
+}
+
+func WithinLines(n int) Distance {
+	return Distance{-1, n}
+}
+
+// Part of a document covering hits of several patterns
+type Span struct {
+	Document *Document
+	Start    int   // Start of the first hit
+	End      int   // End of the last hit
+	Hits     []Hit // The hits in the order of the patterns of the query
+}
+
+// Hit reduced to its bounds in the document
+type hitBounds struct {
+	hit        Hit
+	start, end int
+}
+
+func hitEnd(hit Hit) int {
+	return hit.Position() + len(hit.CharContext(0, 0).Pattern())
+}
+
+func documentHitBounds(result SearchResult, document int) []hitBounds {
+	hits := result.DocumentHits(document)
+	r := make([]hitBounds, len(hits))
+	for i, hit := range hits {
+		r[i] = hitBounds{hit, hit.Position(), hitEnd(hit)}
+	}
+	return r
+}
+
+// Checks the distance of the hits, a must not start after b
+func (this *Distance) accepts(content []byte, a, b *hitBounds) bool {
+	gap := b.start - a.end
+	if gap < 0 {
+		gap = 0
+	}
+	if this.Bytes >= 0 && gap > this.Bytes {
+		return false
+	}
+	if this.Lines >= 0 {
+		lines := 0
+		for i := a.end; i < b.start; i++ {
+			if isNewLine(content, int32(i)) > 0 {
+				lines++
+				if lines > this.Lines {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
+
+func newSpan(document *Document, first, second *hitBounds) *Span {
+	start, end := first.start, first.end
+	if second.start < start {
+		start = second.start
+	}
+	if second.end > end {
+		end = second.end
+	}
+	return &Span{document, start, end, []Hit{first.hit, second.hit}}
+}
+
+// Merges the hits of both results sorted by position in each document containing both of
+// them. The pair function returns spans pairing hits of the first and the second result.
+func mergeDocuments(first, second SearchResult, pair func(document *Document, a []hitBounds, b []hitBounds) []*Span) []*Span {
+	r := make([]*Span, 0)
+	for _, doc := range intersectDocuments(first.Documents(), second.Documents()) {
+		a := documentHitBounds(first, doc)
+		b := documentHitBounds(second, doc)
+		r = append(r, pair(a[0].hit.Document(), a, b)...)
+	}
+	return r
+}
+
+// Finds the hits of the first result with a hit of the second one nearby, either before or
+// after it. Each such hit is paired with the closest hit of the second result.
+func Near(first, second SearchResult, within Distance) []*Span {
+	return mergeDocuments(first, second, func(document *Document, a []hitBounds, b []hitBounds) []*Span {
+		r := make([]*Span, 0)
+		j := 0
+		for i := range a {
+			for j < len(b) && b[j].start < a[i].start {
+				j++
+			}
+			after := j
+			if after < len(b) && b[after].start == a[i].start && b[after].end == a[i].end {
+				after++ // the same occurrence when both results are of the same pattern
+			}
+			var best *hitBounds
+			bestGap := 0
+			if j > 0 && within.accepts(document.Content, &b[j-1], &a[i]) {
+				best, bestGap = &b[j-1], a[i].start-b[j-1].end
+			}
+			if after < len(b) && within.accepts(document.Content, &a[i], &b[after]) {
+				if gap := b[after].start - a[i].end; best == nil || gap < bestGap {
+					best = &b[after]
+				}
+			}
+			if best != nil {
+				r = append(r, newSpan(document, &a[i], best))
+			}
+		}
+		return r
+	})
+}
+
+// Finds the hits of the first result followed by a hit of the second result in the same
+// document. Each such hit is paired with the nearest following hit of the second result.
+func Followed(first, second SearchResult, within Distance) []*Span {
+	return mergeDocuments(first, second, func(document *Document, a []hitBounds, b []hitBounds) []*Span {
+		r := make([]*Span, 0)
+		j := 0
+		for i := range a {
+			for j < len(b) && b[j].start < a[i].end {
+				j++
+			}
+			if j == len(b) {
+				break
+			}
+			if within.accepts(document.Content, &a[i], &b[j]) {
+				r = append(r, newSpan(document, &a[i], &b[j]))
+			}
+		}
+		return r
+	})
+}
+
+// Context of the whole span given as number of bytes
+func (this *Span) CharContext(charsBefore, charsAfter int) HitContext {
+	if charsBefore < 0 || charsAfter < 0 {
+		panic(ErrNegativeContext)
+	}
+	data := this.Document.Content
+	start, end := int32(this.Start), int32(this.End)
+	beforeStart := checkBeforeSingle(start, int32(charsBefore))
+	afterEnd := checkAfterSingle(int32(len(data)), end, int32(charsAfter))
+	return &HitContextStruct{data, beforeStart, start - beforeStart, end - start, afterEnd - end, nil, 0, 0}
+}
+
+// Context of the whole span given as number of UTF-8 runes
+func (this *Span) RuneContext(runesBefore, runesAfter int) HitContext {
+	return runeContext(this.CharContext(runesBefore*utf8.UTFMax, runesAfter*utf8.UTFMax), runesBefore, runesAfter)
+}
+
+// Context of the whole span given as number of lines
+func (this *Span) LineContext(linesBefore, linesAfter int) HitContext {
+	if linesBefore < 0 || linesAfter < 0 {
+		panic(ErrNegativeContext)
+	}
+	data := this.Document.Content
+	start, end := int32(this.Start), int32(this.End)
+	beforeStart := linesBeforeStartAt(data, start, linesBefore)
+	afterEnd := linesAfterStartAt(data, end, linesAfter)
+	return &HitContextStruct{data, beforeStart, start - beforeStart, end - start, afterEnd - end, nil, 0, 0}
+}
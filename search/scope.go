@@ -0,0 +1,245 @@
+// Search restricted to a subset of documents
+package search
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Search over a subset of documents of another search. The documents in the scope are
+// renumbered from zero in the order of the underlying search, their ids are preserved.
+type Scope struct {
+	search    Search
+	documents []int   // indexes of the documents in scope in the underlying search, ascending
+	local     []int32 // index in scope of each document of the underlying search, -1 if out of scope
+}
+
+type ScopeSearchResult struct {
+	scope    *Scope
+	result   SearchResult // hits of the documents in scope only
+	posIndex lazyPositionIndex
+}
+
+// Restricts the search to the documents accepted by the function
+func NewScope(search Search, accept func(document *Document) bool) *Scope {
+	scope := &Scope{search: search, documents: make([]int, 0), local: make([]int32, search.DocumentCount())}
+	for i := range scope.local {
+		if accept(search.Document(i)) {
+			scope.local[i] = int32(len(scope.documents))
+			scope.documents = append(scope.documents, i)
+		} else {
+			scope.local[i] = -1
+		}
+	}
+	return scope
+}
+
+// Restricts the search to the documents with id matching the glob pattern of path.Match
+func ScopeGlob(search Search, glob string) (*Scope, error) {
+	if _, err := path.Match(glob, ""); err != nil {
+		return nil, err
+	}
+	return NewScope(search, func(document *Document) bool {
+		matched, _ := path.Match(glob, document.Id)
+		return matched
+	}), nil
+}
+
+// Restricts the search to the documents with id starting with the prefix, e.g. a directory
+func ScopePrefix(search Search, prefix string) *Scope {
+	return NewScope(search, func(document *Document) bool {
+		return strings.HasPrefix(document.Id, prefix)
+	})
+}
+
+// Restricts the search to the documents with the given indexes
+func ScopeDocuments(search Search, indexes ...int) (*Scope, error) {
+	selected := make(map[int]bool)
+	for _, idx := range indexes {
+		if idx < 0 || idx >= search.DocumentCount() {
+			return nil, fmt.Errorf("%w: %v not in [0, %v)", ErrDocumentIndex, idx, search.DocumentCount())
+		}
+		selected[idx] = true
+	}
+	return NewScope(search, func(document *Document) bool {
+		return selected[document.Index]
+	}), nil
+}
+
+func (this *Scope) DocumentCount() int {
+	return len(this.documents)
+}
+
+func (this *Scope) Document(idx int) *Document {
+	if err := checkDocumentIndex(idx, len(this.documents)); err != nil {
+		panic(err)
+	}
+	r := *this.search.Document(this.documents[idx])
+	r.Index = idx
+	return &r
+}
+
+// Index of the document of the scope in the underlying search
+func (this *Scope) UnderlyingIndex(idx int) int {
+	if err := checkDocumentIndex(idx, len(this.documents)); err != nil {
+		panic(err)
+	}
+	return this.documents[idx]
+}
+
+// The hit limit applies to the hits in scope, so the underlying search is not limited
+func (this *Scope) Find(pattern []byte, options ...FindOption) SearchResult {
+	unlimited := append(options[:len(options):len(options)], MaxHits(0))
+	result := this.filter(this.search.Find(pattern, unlimited...))
+	if result.IsEmpty() {
+		return EmptySearchResult(pattern)
+	}
+	return newFindOptions(options).limit(&ScopeSearchResult{scope: this, result: result})
+}
+
+// The hits of the underlying search bound the hits in scope
+func (this *Scope) countHits(pattern []byte, options *FindOptions) int {
+	return estimateHits(this.search, pattern, options)
+}
+
+// Removes hits of the documents out of scope. With document array only the hits of the
+// documents in scope are visited, so results entirely in or out of scope are not scanned.
+func (this *Scope) filter(result SearchResult) SearchResult {
+	if result.IsEmpty() {
+		return result
+	}
+	if multi, ok := result.(*MultiDocumentSearchResult); ok && multi.esa.Documents != nil {
+		return this.filterByDocumentArray(multi)
+	}
+	return newSubsetSearchResult(result, func(hitIdx int) bool {
+		return this.local[result.documentIndex(hitIdx)] != -1
+	})
+}
+
+// Collects the hits of the distinct documents of the result that are in scope
+func (this *Scope) filterByDocumentArray(result *MultiDocumentSearchResult) SearchResult {
+	documents := result.esa.Documents
+	start, end := result.interval.Start, result.interval.End
+	hits := make([]int32, 0)
+	documents.ForEachDistinct(start, end, func(doc int32) {
+		if this.local[doc] != -1 {
+			documents.ForEachSuffix(start, end, doc, func(i int32) {
+				hits = append(hits, i-start)
+			})
+		}
+	})
+	if len(hits) == 0 {
+		return EmptySearchResult(result.Pattern())
+	}
+	if len(hits) == result.Size() {
+		return result
+	}
+	sort.Slice(hits, func(a, b int) bool { return hits[a] < hits[b] })
+	return &SubsetSearchResult{result: result, hits: hits, total: len(hits)}
+}
+
+func (this *ScopeSearchResult) IsEmpty() bool {
+	return false
+}
+
+func (this *ScopeSearchResult) Size() int {
+	return this.result.Size()
+}
+
+func (this *ScopeSearchResult) Hit(hitIdx int) Hit {
+	return &HitStruct{this, hitIdx}
+}
+
+func (this *ScopeSearchResult) PatternLength() int {
+	return this.result.PatternLength()
+}
+
+func (this *ScopeSearchResult) Pattern() []byte {
+	return this.result.Pattern()
+}
+
+func (this *ScopeSearchResult) positionIndex() *positionIndex {
+	return this.posIndex.get(this)
+}
+
+func (this *ScopeSearchResult) heldHits() int {
+	return this.Size() + this.result.heldHits()
+}
+
+func (this *ScopeSearchResult) HasGlobalPosition(position int) bool {
+	return this.positionIndex().findGlobal(this, position) != -1
+}
+
+func (this *ScopeSearchResult) HitWithGlobalPosition(position int) Hit {
+	return hitOrNil(this, this.positionIndex().findGlobal(this, position))
+}
+
+func (this *ScopeSearchResult) HasPosition(document, position int) bool {
+	return this.positionIndex().find(this, document, position) != -1
+}
+
+func (this *ScopeSearchResult) HitWithPosition(document, position int) Hit {
+	return hitOrNil(this, this.positionIndex().find(this, document, position))
+}
+
+func (this *ScopeSearchResult) Positions() []int {
+	r := make([]int, this.Size())
+	for i := range r {
+		r[i] = this.position(i)
+	}
+	return r
+}
+
+func (this *ScopeSearchResult) Total() int {
+	return this.Size()
+}
+
+func (this *ScopeSearchResult) ForEachHit(f func(hit Hit) bool) {
+	forEachHit(this, f)
+}
+
+func (this *ScopeSearchResult) Page(offset, limit int) *HitPage {
+	return page(this, offset, limit)
+}
+
+func (this *ScopeSearchResult) PageAfter(cursor string, limit int) (*HitPage, error) {
+	return pageAfter(this, cursor, limit)
+}
+
+func (this *ScopeSearchResult) Documents() []int {
+	return this.positionIndex().distinctDocuments(this)
+}
+
+func (this *ScopeSearchResult) DocumentHits(document int) []Hit {
+	return this.positionIndex().documentHits(this, document)
+}
+
+func (this *ScopeSearchResult) document(hitIdx int) *Document {
+	return this.scope.Document(this.documentIndex(hitIdx))
+}
+
+func (this *ScopeSearchResult) documentIndex(hitIdx int) int {
+	return int(this.scope.local[this.result.documentIndex(hitIdx)])
+}
+
+func (this *ScopeSearchResult) globalPosition(hitIdx int) int {
+	return this.result.globalPosition(hitIdx)
+}
+
+func (this *ScopeSearchResult) position(hitIdx int) int {
+	return this.result.position(hitIdx)
+}
+
+func (this *ScopeSearchResult) charContext(hitIndex int, charsBefore, charsAfter int) HitContext {
+	return this.result.charContext(hitIndex, charsBefore, charsAfter)
+}
+
+func (this *ScopeSearchResult) lineContext(hitIndex int, linesBefore, linesAfter int) HitContext {
+	return this.result.lineContext(hitIndex, linesBefore, linesAfter)
+}
+
+func (this *ScopeSearchResult) lineIndex(hitIndex int) *lineIndex {
+	return this.result.lineIndex(hitIndex)
+}
@@ -15,11 +15,13 @@ type Document struct {
 type SingleDocumentSearch struct {
 	esa   *esa.EnhancedSuffixArray
 	docId string
+	lines *lineIndexes
 }
 
 type SingleDocumentSearchResult struct {
-	SingleDocumentSearch
+	*SingleDocumentSearch
 	interval esa.Interval
+	posIndex lazyPositionIndex
 }
 
 type HitContext interface {
@@ -28,15 +30,35 @@ type HitContext interface {
 	After() []byte
 	HighlightStart() int // Length of string returned by Before() method
 	HighlightEnd() int   // Length of before string + length of pattern
+	StartLine() int      // Number of the line where the context starts, the first line is 1
+	PatternLine() int    // Number of the line where the pattern starts
+	EndLine() int        // Number of the line containing the last byte of the context
+
+	TruncatedBefore() bool // The context starts inside of a line due to a size limit
+	TruncatedAfter() bool  // The context ends inside of a line due to a size limit
+
+	documentRange() (start, end int32) // Byte range of the context in the document
 }
 
 // Represents one occurrence of the pattern in the text composed of one or more documents
 type Hit interface {
-	GlobalPosition() int                                // global position in concatenated string of all documents including separators (will never return position inside of the separator)
+	GlobalPosition() int                                // global position in concatenated string of all documents including separators (will never return position inside of the separator), see WhitespaceInsensitiveSearch for an exception
 	Position() int                                      // position inside of the document, i.e. number of bytes from the document start.
 	Document() *Document                                // The document this hit was found in
 	CharContext(charsBefore, charsAfter int) HitContext // Context of the found pattern inside of the document given as number of characters
+	RuneContext(runesBefore, runesAfter int) HitContext // Context given as number of UTF-8 runes, never splits a rune
 	LineContext(linesBefore, linesAfter int) HitContext
+	Line() int       // Number of the line containing the hit, the first line is 1
+	Column() int     // Number of bytes from the line start to the hit plus one
+	RuneColumn() int // Number of UTF-8 runes from the line start to the hit plus one
+
+	// Line context of at most maxBytes bytes centered on the hit. The pattern is never cut, so
+	// the context is just the pattern if it's longer than maxBytes.
+	BoundedLineContext(linesBefore, linesAfter, maxBytes int) HitContext
+
+	// Lines around the hit with all hits of the pattern in them highlighted
+	StructuredContext(linesBefore, linesAfter int) *StructuredContext
+	BoundedStructuredContext(linesBefore, linesAfter, maxBytes int) *StructuredContext
 }
 
 // Result of the search for pattern in the text indexed by Search
@@ -51,18 +73,36 @@ type SearchResult interface {
 	HasPosition(document, position int) bool
 	HitWithPosition(document, position int) Hit
 	Positions() []int
+	Total() int                                           // Number of hits including those left out due to the MaxHits option
+	ForEachHit(f func(hit Hit) bool)                      // Calls f with the hits in the order of their indexes until it returns false
+	Page(offset, limit int) *HitPage                      // Hits sorted by document and position, skipping the first offset of them
+	PageAfter(cursor string, limit int) (*HitPage, error) // Hits following the ones of the page with the cursor
+	Documents() []int                                     // Indexes of the documents containing the pattern in ascending order
+	DocumentHits(document int) []Hit                      // Hits in the given document sorted by position
 
 	document(hitIndex int) *Document
+	documentIndex(hitIndex int) int
 	globalPosition(hitIndex int) int
 	position(hitIndex int) int
 	charContext(hitIndex int, charsBefore, charsAfter int) HitContext
 	lineContext(hitIndex int, linesBefore, linesAfter int) HitContext
+	lineIndex(hitIndex int) *lineIndex
+	positionIndex() *positionIndex
+	heldHits() int // Number of hits of the result and of the results it's built on
 }
 
+// Searches are safe for concurrent use by multiple goroutines once constructed, and so are the
+// search results they return, including the caches built on first use.
 type Search interface {
 	DocumentCount() int
 	Document(i int) *Document
-	Find(pattern []byte) SearchResult
+	Find(pattern []byte, options ...FindOption) SearchResult
+}
+
+// Search able to look for a whole dictionary of patterns in one pass over the index
+type BatchSearch interface {
+	Search
+	FindAll(patterns [][]byte, options ...FindOption) []SearchResult // i-th result belongs to i-th pattern
 }
 
 func NewSingle(docId string, docContent []byte) (*SingleDocumentSearch, error) {
@@ -73,6 +113,7 @@ func NewSingle(docId string, docContent []byte) (*SingleDocumentSearch, error) {
 		return nil, err
 	}
 	search.esa = esa
+	search.lines = newLineIndexes(1)
 	return search, nil
 }
 
@@ -82,7 +123,7 @@ func (*SingleDocumentSearch) DocumentCount() int {
 
 func (search *SingleDocumentSearch) Document(idx int) *Document {
 	if idx != 0 {
-		panic("Single document search contains only index 0")
+		panic(fmt.Errorf("%w: single document search contains only index 0", ErrDocumentIndex))
 	}
 	r := new(Document)
 	r.Content = search.esa.Data
@@ -91,15 +132,52 @@ func (search *SingleDocumentSearch) Document(idx int) *Document {
 	return r
 }
 
-func (search *SingleDocumentSearch) Find(pattern []byte) SearchResult {
+func (search *SingleDocumentSearch) Find(pattern []byte, options ...FindOption) SearchResult {
+	findOptions := newFindOptions(options)
+	if findOptions.IgnoreCase {
+		intervals := search.esa.FindFold(pattern, search.esa.Match)
+		results := make([]SearchResult, len(intervals))
+		for i := range intervals {
+			results[i] = search.newResult(pattern, intervals[i], new(FindOptions))
+		}
+		return findOptions.filter(newUnionSearchResult(pattern, results))
+	}
 	interval := search.esa.Find(pattern, search.esa.Match)
+	return search.newResult(pattern, interval, findOptions)
+}
+
+func (search *SingleDocumentSearch) countHits(pattern []byte, options *FindOptions) int {
+	if options.IgnoreCase {
+		return foundSize(search.esa.FindFold(pattern, search.esa.Match))
+	}
+	return foundSize([]*esa.Interval{search.esa.Find(pattern, search.esa.Match)})
+}
+
+// Case insensitive patterns are looked up one by one, each of them in all of its case variants
+func (search *SingleDocumentSearch) FindAll(patterns [][]byte, options ...FindOption) []SearchResult {
+	findOptions := newFindOptions(options)
+	r := make([]SearchResult, len(patterns))
+	if findOptions.IgnoreCase {
+		for i := range r {
+			r[i] = search.Find(patterns[i], options...)
+		}
+		return r
+	}
+	intervals := search.esa.FindAll(patterns, search.esa.Match)
+	for i := range r {
+		r[i] = search.newResult(patterns[i], intervals[i], findOptions)
+	}
+	return r
+}
+
+func (search *SingleDocumentSearch) newResult(pattern []byte, interval *esa.Interval, options *FindOptions) SearchResult {
 	if interval == nil {
 		return EmptySearchResult(pattern)
 	}
 	sr := new(SingleDocumentSearchResult)
 	sr.interval = *interval
-	sr.SingleDocumentSearch = *search
-	return sr
+	sr.SingleDocumentSearch = search
+	return options.filter(sr)
 }
 
 func (this *SingleDocumentSearchResult) IsEmpty() bool {
@@ -112,7 +190,7 @@ func (this *SingleDocumentSearchResult) Size() int {
 
 func (this *SingleDocumentSearchResult) globalPosition(hitIdx int) int {
 	if hitIdx < 0 || int32(hitIdx) >= int32(this.Size()) {
-		panic(fmt.Sprintf("Hit index %v exceeds the search result size %v", hitIdx, this.Size()))
+		panic(fmt.Errorf("%w: %v exceeds the search result size %v", ErrHitIndex, hitIdx, this.Size()))
 	}
 	return int(this.esa.SA[this.interval.Start+int32(hitIdx)])
 }
@@ -121,6 +199,10 @@ func (search *SingleDocumentSearchResult) document(hitIdx int) *Document {
 	return search.Document(0)
 }
 
+func (search *SingleDocumentSearchResult) documentIndex(hitIdx int) int {
+	return 0
+}
+
 func (this *SingleDocumentSearchResult) position(hitIdx int) int {
 	return this.globalPosition(hitIdx)
 }
@@ -138,20 +220,28 @@ func (this *SingleDocumentSearchResult) Pattern() []byte {
 	return this.esa.Data[patternStart : patternStart+this.interval.Length]
 }
 
+func (this *SingleDocumentSearchResult) positionIndex() *positionIndex {
+	return this.posIndex.get(this)
+}
+
+func (this *SingleDocumentSearchResult) heldHits() int {
+	return this.Size()
+}
+
 func (this *SingleDocumentSearchResult) HasGlobalPosition(position int) bool {
-	return false
+	return this.positionIndex().findGlobal(this, position) != -1
 }
 
 func (this *SingleDocumentSearchResult) HitWithGlobalPosition(position int) Hit {
-	return nil
+	return hitOrNil(this, this.positionIndex().findGlobal(this, position))
 }
 
 func (this *SingleDocumentSearchResult) HasPosition(document, position int) bool {
-	return false
+	return this.positionIndex().find(this, document, position) != -1
 }
 
 func (this *SingleDocumentSearchResult) HitWithPosition(document, position int) Hit {
-	return nil
+	return hitOrNil(this, this.positionIndex().find(this, document, position))
 }
 
 func (this *SingleDocumentSearchResult) Positions() []int {
@@ -162,6 +252,30 @@ func (this *SingleDocumentSearchResult) Positions() []int {
 	return r
 }
 
+func (this *SingleDocumentSearchResult) Total() int {
+	return this.Size()
+}
+
+func (this *SingleDocumentSearchResult) ForEachHit(f func(hit Hit) bool) {
+	forEachHit(this, f)
+}
+
+func (this *SingleDocumentSearchResult) Page(offset, limit int) *HitPage {
+	return page(this, offset, limit)
+}
+
+func (this *SingleDocumentSearchResult) PageAfter(cursor string, limit int) (*HitPage, error) {
+	return pageAfter(this, cursor, limit)
+}
+
+func (this *SingleDocumentSearchResult) Documents() []int {
+	return this.positionIndex().distinctDocuments(this)
+}
+
+func (this *SingleDocumentSearchResult) DocumentHits(document int) []Hit {
+	return this.positionIndex().documentHits(this, document)
+}
+
 func ifelse(expr bool, onTrue int32, onFalse int32) int32 {
 	if expr {
 		return onTrue
@@ -182,7 +296,7 @@ func checkAfterSingle(dataLen int32, pos int32, maxSize int32) int32 {
 
 func (this *SingleDocumentSearchResult) charContext(hitIndex int, charsBefore, charsAfter int) HitContext {
 	if charsBefore < 0 || charsAfter < 0 {
-		panic("Negative context length")
+		panic(ErrNegativeContext)
 	}
 	pos := int32(this.globalPosition(hitIndex))
 	beforeStart := checkBeforeSingle(pos, int32(charsBefore))
@@ -192,7 +306,14 @@ func (this *SingleDocumentSearchResult) charContext(hitIndex int, charsBefore, c
 		beforeStart,
 		pos - beforeStart,
 		this.interval.Length,
-		afterEnd - pos - this.interval.Length}
+		afterEnd - pos - this.interval.Length,
+		this,
+		hitIndex,
+		0}
+}
+
+func (this *SingleDocumentSearchResult) lineIndex(hitIndex int) *lineIndex {
+	return this.lines.get(this.Document(0))
 }
 
 func isNewLine(data []byte, i int32) int32 {
@@ -211,16 +332,12 @@ func isNewLine(data []byte, i int32) int32 {
 	}
 }
 
-func (this *SingleDocumentSearchResult) isNewLine(i int32) int32 {
-	return isNewLine(this.esa.Data, i)
-}
-
-func (this *SingleDocumentSearchResult) linesBeforeStart(hitIndex int, maxLines int) int32 {
-	j := int32(this.globalPosition(hitIndex))
+func linesBeforeStartAt(data []byte, pos int32, maxLines int) int32 {
+	j := pos
 	newLine := int32(0)
 	lineCount := int32(0)
 	for j >= 0 && lineCount <= int32(maxLines) {
-		newLine = this.isNewLine(j)
+		newLine = isNewLine(data, j)
 		if newLine > 0 {
 			lineCount++
 		}
@@ -229,12 +346,12 @@ func (this *SingleDocumentSearchResult) linesBeforeStart(hitIndex int, maxLines
 	return j + 1 + newLine
 }
 
-func (this *SingleDocumentSearchResult) linesAfterStart(hitIndex int, maxLines int) int32 {
-	j := int32(this.globalPosition(hitIndex)) + this.interval.Length
+func linesAfterStartAt(data []byte, end int32, maxLines int) int32 {
+	j := end
 	lineCount := int32(0)
-	dataLength := int32(len(this.esa.Data))
+	dataLength := int32(len(data))
 	for j < dataLength && lineCount <= int32(maxLines) {
-		if this.isNewLine(j) > 0 {
+		if isNewLine(data, j) > 0 {
 			lineCount++
 		}
 		j++
@@ -242,9 +359,17 @@ func (this *SingleDocumentSearchResult) linesAfterStart(hitIndex int, maxLines i
 	return ifelse(j == dataLength, j, j-1)
 }
 
+func (this *SingleDocumentSearchResult) linesBeforeStart(hitIndex int, maxLines int) int32 {
+	return linesBeforeStartAt(this.esa.Data, int32(this.globalPosition(hitIndex)), maxLines)
+}
+
+func (this *SingleDocumentSearchResult) linesAfterStart(hitIndex int, maxLines int) int32 {
+	return linesAfterStartAt(this.esa.Data, int32(this.globalPosition(hitIndex))+this.interval.Length, maxLines)
+}
+
 func (this *SingleDocumentSearchResult) lineContext(hitIndex int, linesBefore, linesAfter int) HitContext {
 	if linesBefore < 0 || linesAfter < 0 {
-		panic("Negative context length")
+		panic(ErrNegativeContext)
 	}
 	patternStart := int32(this.globalPosition(hitIndex))
 	beforeStart := this.linesBeforeStart(hitIndex, linesBefore)
@@ -254,5 +379,8 @@ func (this *SingleDocumentSearchResult) lineContext(hitIndex int, linesBefore, l
 		beforeStart,
 		patternStart - beforeStart,
 		this.interval.Length,
-		afterEnd - patternStart - this.interval.Length}
+		afterEnd - patternStart - this.interval.Length,
+		this,
+		hitIndex,
+		0}
 }
@@ -1,7 +1,12 @@
 package search
 
 import (
+	"errors"
 	"fmt"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
 
 	"github.com/golang-collections/collections/set"
@@ -92,6 +97,12 @@ func (tsr *TestSearchResult) assertPositions(positions ...int) {
 	}
 }
 
+func (tsr *TestSearchResult) assertSize(size int) {
+	if tsr.result.Size() != size {
+		tsr.t.Errorf("Expected %v hits of %s but got %v", size, tsr.result.Pattern(), tsr.result.Size())
+	}
+}
+
 func (tsr *TestSearchResult) assertSingleHit() *TestHit {
 	if tsr.result.Size() != 1 {
 		tsr.t.Errorf("Expected single hit but got %v", tsr.result.Size())
@@ -380,7 +391,1313 @@ func TestLineContext2(t *testing.T) {
 	hit.assertLinesBelow(3, "cc\nddd\neee")
 }
 
+func TestCharContext(t *testing.T) {
+	search := testSearchIn(t, "abcdefGGhijklm", "nopGGq")
+	for _, e := range []struct {
+		document, before, after int
+		expected                string
+	}{
+		{0, 2, 4, "ef|GG|hijk"},
+		{0, 5, 0, "bcdef|GG|"},
+		{0, 0, 3, "|GG|hij"},
+		{1, 10, 1, "nop|GG|q"},
+	} {
+		ctx := search.find("GG").result.HitWithPosition(e.document, 6-3*e.document).CharContext(e.before, e.after)
+		if computed := fmt.Sprintf("%s|%s|%s", ctx.Before(), ctx.Pattern(), ctx.After()); computed != e.expected {
+			t.Errorf("Expected context %v of %v chars before and %v after but got %v", e.expected, e.before, e.after, computed)
+		}
+	}
+}
+
 func TestAAAA(t *testing.T) {
 	search := testSearchIn(t, "aaaaaaaaaaaaaaaaaaaa")
 	search.find("aaaa").assertPositions(0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16)
 }
+
+func TestWhitespaceInsensitive(t *testing.T) {
+	search, err := NewWhitespaceInsensitiveSingle("testDoc", []byte("x foo(a,\n    b) y\nfoo(a,\tb)"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := &TestSearch{search, t}
+	ts.find("foo(a, b)").assertPositions(2, 18)
+	ts.find("foo(a,\n\t\tb)").assertPositions(2, 18)
+	ts.find("a, b) y foo").assertSingleHit().assertPosition(6).assertCtx(1, "(", "(")
+	result := search.Find([]byte("foo(a, b)"))
+	for i := 0; i < result.Size(); i++ {
+		hit := result.Hit(i)
+		expected := map[int]string{2: "foo(a,\n    b)", 18: "foo(a,\tb)"}[hit.Position()]
+		if pattern := string(hit.LineContext(0, 0).Pattern()); pattern != expected {
+			t.Errorf("Expected original text %q as pattern context but got %q", expected, pattern)
+		}
+	}
+}
+
+func TestWhitespaceInsensitiveMulti(t *testing.T) {
+	offsets, combined := combine([]string{"if  (a)\n{", "if (a) {", "if(a){"})
+	search, err := NewWhitespaceInsensitiveMulti(combined, offsets, testIds(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := &TestSearch{search, t}
+	result := ts.find("if (a) {")
+	result.assertSize(2)
+	result.assertPositions(0)
+	if documents := fmt.Sprint(result.result.Documents()); documents != "[0 1]" {
+		t.Errorf("Expected hits in documents [0 1] but got %v", documents)
+	}
+	ts.find("(a){").assertSingleHit().assertDocument(2).assertPosition(2).assertCtx(10, "if", "")
+	hit := search.Find([]byte("if (a) {")).Hit(0)
+	if hit.GlobalPosition() != offsets[hit.Document().Index] {
+		t.Errorf("Expected global position %v but got %v", offsets[hit.Document().Index], hit.GlobalPosition())
+	}
+	for _, pattern := range []string{"if (a) {", "a", "{"} {
+		result := search.Find([]byte(pattern))
+		for i := 0; i < result.Size(); i++ {
+			hit := result.Hit(i)
+			found := result.HitWithGlobalPosition(hit.GlobalPosition())
+			if !result.HasGlobalPosition(hit.GlobalPosition()) || found == nil ||
+				found.Document().Index != hit.Document().Index || found.Position() != hit.Position() {
+				t.Errorf("Hit %v of %q not found by its global position %v", i, pattern, hit.GlobalPosition())
+			}
+		}
+	}
+}
+
+func (ts *TestSearch) findWith(text string, options ...FindOption) *TestSearchResult {
+	return &TestSearchResult{*ts, ts.search.Find([]byte(text), options...)}
+}
+
+func TestMatchFlags(t *testing.T) {
+	search := testSearchIn(t, "foo bar\nfoobar foo_x\r\nfoo\n(foo)")
+	search.findWith("foo").assertPositions(0, 8, 15, 22, 27)
+	search.findWith("foo", Match(WholeWord)).assertPositions(0, 22, 27)
+	search.findWith("foo", Match(LineStart)).assertPositions(0, 8, 22)
+	search.findWith("foo", Match(LineEnd)).assertPositions(22)
+	search.findWith("foo", Match(WholeLine)).assertPositions(22)
+	search.findWith("bar", Match(LineEnd)).assertPositions(4)
+	search.findWith("bar", Match(WholeWord|LineStart)).assertPositions()
+	if !search.findWith("foo_", Match(WholeWord)).result.IsEmpty() {
+		t.Errorf("Expected empty result")
+	}
+}
+
+func TestMatchFlagsWordChars(t *testing.T) {
+	search := testSearchIn(t, "čaj čajíček")
+	search.findWith("čaj", Match(WholeWord)).assertPositions(0, 5)
+	search.findWith("čaj", Match(WholeWord), WithWordChars(UnicodeWordChars)).assertPositions(0)
+}
+
+func TestMatchFlagsMulti(t *testing.T) {
+	search := testSearchIn(t, "xfoo", "foo", "foox", "a foo")
+	search.findWith("foo", Match(WholeWord)).assertPositions(0, 2)
+	search.findWith("foo", Match(WholeLine)).assertSingleHit().assertDocument(1)
+	search.findWith("foo", Match(LineStart)).assertPositions(0, 0)
+	if size := search.findWith("foo", Match(LineEnd)).result.Size(); size != 3 {
+		t.Errorf("Expected 3 hits but got %v", size)
+	}
+}
+
+func TestFindAll(t *testing.T) {
+	for _, search := range []*TestSearch{
+		testSearchIn(t, "abracadabra, abracadabra"),
+		testSearchIn(t, "abra", "cadabra", "bracket", "arabica"),
+	} {
+		patterns := [][]byte{[]byte("abra"), []byte("brac"), []byte("a"), []byte("ab"), []byte("raca"), []byte("zz"), []byte("abrab")}
+		results := search.search.(BatchSearch).FindAll(patterns)
+		for i := range patterns {
+			expected := search.search.Find(patterns[i]).Positions()
+			(&TestSearchResult{*search, results[i]}).assertPositions(expected...)
+		}
+	}
+	search := testSearchIn(t, "Abra", "cadABRA", "bracket")
+	patterns := [][]byte{[]byte("abra"), []byte("BRA"), []byte("zz")}
+	results := search.search.(BatchSearch).FindAll(patterns, IgnoreCase(true))
+	for i, expected := range []int{2, 3, 0} {
+		(&TestSearchResult{*search, results[i]}).assertSize(expected)
+	}
+}
+
+func assertCompletions(t *testing.T, completions []Completion, expected ...string) {
+	computed := make([]string, len(completions))
+	for i, c := range completions {
+		computed[i] = fmt.Sprintf("%s:%v:%v", c.Text, c.Count, c.Documents)
+	}
+	if fmt.Sprint(computed) != fmt.Sprint(expected) {
+		t.Errorf("Expected completions %v but got %v", expected, computed)
+	}
+}
+
+func TestComplete(t *testing.T) {
+	search, err := NewSingle("testDoc", []byte("print printf println printf print\nprintf x"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	words := CompletionOptions{Delimiters: []byte(" \n")}
+	assertCompletions(t, search.Complete([]byte("pri"), 2, words), "printf:3:1", "print:2:1")
+	assertCompletions(t, search.Complete([]byte("pri"), 5, words), "printf:3:1", "print:2:1", "println:1:1")
+	assertCompletions(t, search.Complete([]byte("print"), 10, CompletionOptions{MaxLength: 1}), "printf:3:1", "print\n:1:1", "print :1:1", "printl:1:1")
+	assertCompletions(t, search.Complete([]byte("x"), 3, words), "x:1:1")
+	assertCompletions(t, search.Complete([]byte("zzz"), 3, words))
+	assertCompletions(t, search.Complete(nil, 3, words))
+}
+
+func TestCompleteMulti(t *testing.T) {
+	offsets, combined := combine([]string{"go gopher", "gopher golang", "golang gopher gopher"})
+	search, err := NewMulti(combined, offsets, testIds(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	words := CompletionOptions{Delimiters: []byte(" ")}
+	assertCompletions(t, search.Complete([]byte("go"), 3, words), "gopher:4:3", "golang:2:2", "go:1:1")
+	assertCompletions(t, search.Complete([]byte{}, 3, words))
+}
+
+func assertDistribution(t *testing.T, distribution []NextByte, expected ...string) {
+	computed := make([]string, len(distribution))
+	for i, b := range distribution {
+		next := string(b.Byte)
+		if b.End {
+			next = "$"
+		}
+		computed[i] = fmt.Sprintf("%s:%v:%v:%s", next, b.Count, b.Documents, b.Continuation)
+	}
+	if fmt.Sprint(computed) != fmt.Sprint(expected) {
+		t.Errorf("Expected distribution %v but got %v", expected, computed)
+	}
+}
+
+func TestNextByteDistribution(t *testing.T) {
+	search, err := NewSingle("testDoc", []byte("abracadabra"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertDistribution(t, search.NextByteDistribution([]byte("a")), "$:1:0:", "b:2:0:", "c:1:0:", "d:1:0:")
+	assertDistribution(t, search.NextByteDistribution([]byte("a"), ExtendToBranch()), "$:1:0:", "b:2:0:bra", "c:1:0:cadabra", "d:1:0:dabra")
+	assertDistribution(t, search.NextByteDistribution([]byte("ab"), ExtendToBranch()), "r:2:0:ra")
+	assertDistribution(t, search.NextByteDistribution([]byte("abra")), "$:1:0:", "c:1:0:")
+	assertDistribution(t, search.NextByteDistribution([]byte("x")))
+}
+
+func TestNextByteDistributionMulti(t *testing.T) {
+	offsets, combined := combine([]string{"abc", "abd", "ab", "xabd"})
+	search, err := NewMulti(combined, offsets, testIds(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertDistribution(t, search.NextByteDistribution([]byte("ab"), ByDocument(), ExtendToBranch()), "$:1:1:", "c:1:1:c", "d:2:2:d")
+	assertDistribution(t, search.NextByteDistribution([]byte("b"), ByDocument()), "$:1:1:", "c:1:1:", "d:2:2:")
+	assertDistribution(t, search.NextByteDistribution([]byte("d"), ByDocument()), "$:2:2:")
+}
+
+func (tsr *TestSearchResult) assertHasPosition(document, position int, expected bool) {
+	if tsr.result.HasPosition(document, position) != expected {
+		tsr.t.Errorf("Expected HasPosition(%v, %v) of %s to be %v", document, position, tsr.result.Pattern(), expected)
+	}
+	hit := tsr.result.HitWithPosition(document, position)
+	if (hit != nil) != expected {
+		tsr.t.Errorf("Expected HitWithPosition(%v, %v) of %s to be found: %v", document, position, tsr.result.Pattern(), expected)
+	} else if hit != nil && (hit.Document().Index != document || hit.Position() != position) {
+		tsr.t.Errorf("HitWithPosition(%v, %v) returned hit at %v, %v", document, position, hit.Document().Index, hit.Position())
+	}
+}
+
+func (tsr *TestSearchResult) assertHasGlobalPosition(position int, expected bool) {
+	if tsr.result.HasGlobalPosition(position) != expected {
+		tsr.t.Errorf("Expected HasGlobalPosition(%v) of %s to be %v", position, tsr.result.Pattern(), expected)
+	}
+	hit := tsr.result.HitWithGlobalPosition(position)
+	if (hit != nil) != expected {
+		tsr.t.Errorf("Expected HitWithGlobalPosition(%v) of %s to be found: %v", position, tsr.result.Pattern(), expected)
+	} else if hit != nil && hit.GlobalPosition() != position {
+		tsr.t.Errorf("HitWithGlobalPosition(%v) returned hit at %v", position, hit.GlobalPosition())
+	}
+}
+
+func TestHasPosition(t *testing.T) {
+	search := testSearchIn(t, "abracadabra")
+	result := search.find("a")
+	for _, pos := range []int{0, 3, 5, 7, 10} {
+		result.assertHasPosition(0, pos, true)
+		result.assertHasGlobalPosition(pos, true)
+	}
+	for _, pos := range []int{-1, 1, 2, 4, 6, 8, 9, 11} {
+		result.assertHasPosition(0, pos, false)
+		result.assertHasGlobalPosition(pos, false)
+	}
+	result.assertHasPosition(1, 0, false)
+	search.find("abra").assertHasPosition(0, 7, true)
+	search.find("abra").assertHasPosition(0, 3, false)
+	search.find("xyz").assertHasPosition(0, 0, false)
+	search.findWith("abra", Match(WholeWord)).assertHasPosition(0, 0, false)
+}
+
+func TestHasPositionMulti(t *testing.T) {
+	search := testSearchIn(t, "abc", "cab", "bca", "abcabc")
+	result := search.find("ab")
+	result.assertHasPosition(0, 0, true)
+	result.assertHasPosition(1, 1, true)
+	result.assertHasPosition(3, 0, true)
+	result.assertHasPosition(3, 3, true)
+	result.assertHasPosition(2, 0, false)
+	result.assertHasPosition(1, 0, false)
+	result.assertHasPosition(3, 1, false)
+	result.assertHasPosition(4, 0, false)
+	for i := 0; i < result.result.Size(); i++ {
+		result.assertHasGlobalPosition(result.result.Hit(i).GlobalPosition(), true)
+		result.assertHasGlobalPosition(result.result.Hit(i).GlobalPosition()+1, false)
+	}
+	search.findWith("abc", Match(WholeLine)).assertHasPosition(0, 0, true)
+	search.findWith("abc", Match(WholeLine)).assertHasPosition(3, 0, false)
+}
+
+func hitPositions(hits []Hit) []string {
+	r := make([]string, len(hits))
+	for i, hit := range hits {
+		r[i] = fmt.Sprintf("%v:%v", hit.Document().Index, hit.Position())
+	}
+	return r
+}
+
+func (tsr *TestSearchResult) assertPage(page *HitPage, hasMore bool, expected ...string) {
+	if computed := hitPositions(page.Hits); fmt.Sprint(computed) != fmt.Sprint(expected) {
+		tsr.t.Errorf("Expected page %v but got %v", expected, computed)
+	}
+	if (page.Cursor != "") != hasMore {
+		tsr.t.Errorf("Expected page cursor presence %v but got %q", hasMore, page.Cursor)
+	}
+}
+
+func TestPagination(t *testing.T) {
+	search := testSearchIn(t, "abracadabra")
+	result := search.find("a")
+	result.assertPage(result.result.Page(0, 2), true, "0:0", "0:3")
+	result.assertPage(result.result.Page(2, 2), true, "0:5", "0:7")
+	result.assertPage(result.result.Page(4, 2), false, "0:10")
+	result.assertPage(result.result.Page(6, 2), false)
+	page := result.result.Page(0, 3)
+	next, err := result.result.PageAfter(page.Cursor, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result.assertPage(next, false, "0:7", "0:10")
+	if _, err := result.result.PageAfter("bogus", 3); err == nil {
+		t.Errorf("Expected error for invalid cursor")
+	}
+}
+
+func TestHitIteratorMulti(t *testing.T) {
+	search := testSearchIn(t, "banana", "ananas", "cabana")
+	result := search.find("an")
+	iter := NewHitIterator(result.result)
+	var hits []Hit
+	for iter.Next() {
+		hits = append(hits, iter.Hit())
+		if len(hits) == 3 {
+			break
+		}
+	}
+	resumed, err := ResumeHitIterator(result.result, iter.Cursor())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for resumed.Next() {
+		hits = append(hits, resumed.Hit())
+	}
+	expected := []string{"0:1", "0:3", "1:0", "1:2", "2:3"}
+	if computed := hitPositions(hits); fmt.Sprint(computed) != fmt.Sprint(expected) {
+		t.Errorf("Expected hits %v but got %v", expected, computed)
+	}
+	result.assertPage(result.result.Page(1, 3), true, "0:3", "1:0", "1:2")
+}
+
+func TestHitIteratorBatches(t *testing.T) {
+	search := testSearchIn(t, strings.Repeat("a", 3000))
+	iter := NewHitIterator(search.find("aa").result)
+	count := 0
+	for iter.Next() {
+		if iter.Hit().Position() != count {
+			t.Fatalf("Expected position %v but got %v", count, iter.Hit().Position())
+		}
+		count++
+	}
+	if count != 2999 {
+		t.Errorf("Expected 2999 hits but got %v", count)
+	}
+}
+
+// Result counting the lookups of global positions
+type countingResult struct {
+	SearchResult
+	calls    int
+	posIndex lazyPositionIndex
+}
+
+func (this *countingResult) globalPosition(hitIdx int) int {
+	this.calls++
+	return this.SearchResult.globalPosition(hitIdx)
+}
+
+func (this *countingResult) positionIndex() *positionIndex {
+	return this.posIndex.get(this)
+}
+
+func TestHitIterationLinear(t *testing.T) {
+	n := 100000
+	search := testSearchIn(t, strings.Repeat("a", n+1))
+	result := &countingResult{SearchResult: search.find("a").result}
+	iter := NewHitIterator(result)
+	count := 0
+	for iter.Next() {
+		if iter.Hit().Position() != count {
+			t.Fatalf("Expected position %v but got %v", count, iter.Hit().Position())
+		}
+		count++
+	}
+	if count != n+1 {
+		t.Errorf("Expected %v hits but got %v", n+1, count)
+	}
+	p := page(result, n-1, 10)
+	if len(p.Hits) != 2 || p.Hits[0].Position() != n-1 || p.Cursor != "" {
+		t.Errorf("Unexpected last page %v", hitPositions(p.Hits))
+	}
+	if result.calls > 8*(n+1) {
+		t.Errorf("Expected at most %v global position lookups but got %v", 8*(n+1), result.calls)
+	}
+}
+
+func TestPositionIndexMemory(t *testing.T) {
+	n := 100000
+	result := testSearchIn(t, strings.Repeat("a", n)).find("a").result
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	newPositionIndex(result)
+	runtime.ReadMemStats(&after)
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > uint64(5*n) {
+		t.Errorf("Expected about 4 bytes per hit but %v hits allocated %v bytes", n, allocated)
+	}
+}
+
+func TestSortByKey(t *testing.T) {
+	seed := uint32(11)
+	for _, size := range []int{10, radixSortMinSize, 5000, 200000} {
+		for _, mask := range []int32{0x7fffffff, 0x1ffff, 0xffff} {
+			keys := make([]int32, size)
+			hits := make([]int32, size)
+			for i := range keys {
+				seed = seed*1664525 + 1013904223
+				keys[i] = int32(seed>>1) & mask
+				hits[i] = int32(i)
+			}
+			sortByKey(hits, func(hit int32) int32 { return keys[hit] })
+			seen := make([]bool, size)
+			for i, hit := range hits {
+				if seen[hit] || (i > 0 && keys[hits[i-1]] > keys[hit]) {
+					t.Fatalf("Hits of size %v and mask %x not sorted at %v", size, mask, i)
+				}
+				seen[hit] = true
+			}
+		}
+	}
+}
+
+func TestHitWalkWithDocumentArray(t *testing.T) {
+	texts := make([]string, 300)
+	seed := uint32(7)
+	for i := range texts {
+		text := make([]byte, 5+i%40)
+		for j := range text {
+			seed = seed*1664525 + 1013904223
+			text[j] = "aab c"[seed>>24%5]
+		}
+		texts[i] = string(text)
+	}
+	offsets, combined := combine(texts)
+	withArray, err := NewMulti(combined, offsets, testIds(len(texts)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	withoutArray, err := NewMulti(combined, offsets, testIds(len(texts)), WithoutDocumentArray())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, pattern := range []string{"a", "ab", "c a", "b"} {
+		expected := withoutArray.Find([]byte(pattern))
+		computed := withArray.Find([]byte(pattern))
+		var hits, expectedHits []Hit
+		for iter := NewHitIterator(computed); iter.Next(); {
+			hits = append(hits, iter.Hit())
+		}
+		for iter := NewHitIterator(expected); iter.Next(); {
+			expectedHits = append(expectedHits, iter.Hit())
+		}
+		if fmt.Sprint(hitPositions(hits)) != fmt.Sprint(hitPositions(expectedHits)) {
+			t.Fatalf("Hits of %q differ with document array", pattern)
+		}
+		for _, offset := range []int{0, 1, len(hits) / 3, len(hits) - 5} {
+			p := computed.Page(offset, 7)
+			if fmt.Sprint(hitPositions(p.Hits)) != fmt.Sprint(hitPositions(expectedHits[offset:offset+len(p.Hits)])) {
+				t.Errorf("Page at %v of %q differs with document array", offset, pattern)
+			}
+			if p.Cursor == "" {
+				continue
+			}
+			next, err := computed.PageAfter(p.Cursor, 3)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fmt.Sprint(hitPositions(next.Hits)) != fmt.Sprint(hitPositions(expectedHits[offset+7:offset+10])) {
+				t.Errorf("Page after %v of %q differs with document array", offset, pattern)
+			}
+		}
+	}
+}
+
+func TestDocumentGrouping(t *testing.T) {
+	testDocumentGrouping(t)
+	testDocumentGrouping(t, WithoutDocumentArray())
+}
+
+func testDocumentGrouping(t *testing.T, options ...IndexOption) {
+	offsets, combined := combine([]string{"banana", "apple", "ananas", "cabana", "an"})
+	multi, err := NewMulti(combined, offsets, testIds(5), options...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	search := &TestSearch{multi, t}
+	result := search.find("an").result
+	if documents := fmt.Sprint(result.Documents()); documents != "[0 2 3 4]" {
+		t.Errorf("Expected documents [0 2 3 4] but got %v", documents)
+	}
+	expected := map[int]string{0: "[0:1 0:3]", 1: "[]", 2: "[2:0 2:2]", 3: "[3:3]", 4: "[4:0]", 5: "[]"}
+	for document, hits := range expected {
+		if computed := fmt.Sprint(hitPositions(result.DocumentHits(document))); computed != hits {
+			t.Errorf("Expected hits %v in document %v but got %v", hits, document, computed)
+		}
+	}
+	if documents := fmt.Sprint(search.find("xyz").result.Documents()); documents != "[]" {
+		t.Errorf("Expected no documents but got %v", documents)
+	}
+	if documents := fmt.Sprint(testSearchIn(t, "banana").find("na").result.Documents()); documents != "[0]" {
+		t.Errorf("Expected documents [0] but got %v", documents)
+	}
+	if sorted := result.(*MultiDocumentSearchResult).posIndex.index != nil; sorted != (multi.esa.Documents == nil) {
+		t.Errorf("Expected all hits to be sorted only without document array")
+	}
+}
+
+func TestDocumentArrayOption(t *testing.T) {
+	offsets, combined := combine([]string{"banana", "apple", "ananas", "cabana", "an"})
+	withArray, err := NewMulti(combined, offsets, testIds(5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	offsets, combined = combine([]string{"banana", "apple", "ananas", "cabana", "an"})
+	withoutArray, err := NewMulti(combined, offsets, testIds(5), WithoutDocumentArray())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, pattern := range []string{"a", "an", "ana", "p", "n", "xyz"} {
+		r1 := withArray.Find([]byte(pattern))
+		r2 := withoutArray.Find([]byte(pattern))
+		if fmt.Sprint(r1.Documents()) != fmt.Sprint(r2.Documents()) {
+			t.Errorf("Documents of %v differ: %v and %v", pattern, r1.Documents(), r2.Documents())
+		}
+		for _, doc := range r1.Documents() {
+			if fmt.Sprint(hitPositions(r1.DocumentHits(doc))) != fmt.Sprint(hitPositions(r2.DocumentHits(doc))) {
+				t.Errorf("Hits of %v in document %v differ", pattern, doc)
+			}
+		}
+		d1 := withArray.NextByteDistribution([]byte(pattern), ByDocument())
+		d2 := withoutArray.NextByteDistribution([]byte(pattern), ByDocument())
+		if fmt.Sprint(d1) != fmt.Sprint(d2) {
+			t.Errorf("Distributions of %v differ: %v and %v", pattern, d1, d2)
+		}
+	}
+}
+
+func TestTopDocuments(t *testing.T) {
+	texts := []string{"ab ab", "ab", "ab ab ab", "xy", "ab ab ab", "ba ab ab ab ab"}
+	offsets, combined := combine(texts)
+	search, err := NewMulti(combined, offsets, testIds(len(texts)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	top := search.TopDocuments([]byte("ab"), 3)
+	if fmt.Sprint(top) != "[{5 4} {2 3} {4 3}]" {
+		t.Errorf("Unexpected top documents %v", top)
+	}
+	naive := topDocumentsNaive(search.Find([]byte("ab")), 3)
+	if fmt.Sprint(top) != fmt.Sprint(naive) {
+		t.Errorf("Top documents %v differ from naive %v", top, naive)
+	}
+	if top := search.TopDocuments([]byte("zz"), 3); len(top) != 0 {
+		t.Errorf("Expected no documents but got %v", top)
+	}
+}
+
+func benchmarkCorpus(b *testing.B) *MultiDocumentSearch {
+	texts := make([]string, 2000)
+	seed := uint32(1)
+	for i := range texts {
+		text := make([]byte, 200+i%300)
+		for j := range text {
+			seed = seed*1664525 + 1013904223
+			text[j] = "aab c"[seed>>24%5]
+		}
+		texts[i] = string(text)
+	}
+	offsets, combined := combine(texts)
+	search, err := NewMulti(combined, offsets, testIds(len(texts)))
+	if err != nil {
+		b.Fatal(err)
+	}
+	return search
+}
+
+func BenchmarkTopDocuments(b *testing.B) {
+	search := benchmarkCorpus(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		search.TopDocuments([]byte("a"), 10)
+	}
+}
+
+func BenchmarkTopDocumentsNaive(b *testing.B) {
+	search := benchmarkCorpus(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		topDocumentsNaive(search.Find([]byte("a")), 10)
+	}
+}
+
+func assertBooleanDocuments(t *testing.T, result *BooleanResult, expected string) {
+	docs := make([]int, len(result.Documents))
+	for i, match := range result.Documents {
+		docs[i] = match.Document
+	}
+	if fmt.Sprint(docs) != expected {
+		t.Errorf("Expected documents %v but got %v", expected, docs)
+	}
+}
+
+func TestBooleanQuery(t *testing.T) {
+	search := testSearchIn(t, "foo bar", "foo baz", "bar baz", "foo bar baz", "qux").search
+	foo, bar, baz := Term([]byte("foo")), Term([]byte("bar")), Term([]byte("baz"))
+	assertBooleanDocuments(t, Evaluate(search, And(foo, bar)), "[0 3]")
+	assertBooleanDocuments(t, Evaluate(search, And(foo, bar, Not(baz))), "[0]")
+	assertBooleanDocuments(t, Evaluate(search, Or(foo, bar)), "[0 1 2 3]")
+	assertBooleanDocuments(t, Evaluate(search, Not(Or(foo, bar))), "[4]")
+	assertBooleanDocuments(t, Evaluate(search, And(Not(foo), Not(Term([]byte("qux"))))), "[2]")
+	assertBooleanDocuments(t, Evaluate(search, And(foo, Term([]byte("zzz")))), "[]")
+	result := Evaluate(search, And(Or(foo, Term([]byte("qux"))), Not(bar)))
+	assertBooleanDocuments(t, result, "[1 4]")
+	if fmt.Sprintf("%s", result.Terms) != "[foo qux]" {
+		t.Errorf("Unexpected positive terms %s", result.Terms)
+	}
+	if len(result.Documents[0].Hits[0]) != 1 || len(result.Documents[0].Hits[1]) != 0 || len(result.Documents[1].Hits[1]) != 1 {
+		t.Errorf("Unexpected hits of positive terms %v", result.Documents)
+	}
+}
+
+// Search recording the patterns it was asked to find
+type recordingSearch struct {
+	*MultiDocumentSearch
+	found []string
+}
+
+func (this *recordingSearch) Find(pattern []byte, options ...FindOption) SearchResult {
+	this.found = append(this.found, string(pattern))
+	return this.MultiDocumentSearch.Find(pattern, options...)
+}
+
+func TestBooleanQueryFindsOnlyNeededTerms(t *testing.T) {
+	texts := []string{"foo bar", "foo baz", "bar baz", "foo bar baz"}
+	offsets, combined := combine(texts)
+	multi, err := NewMulti(combined, offsets, testIds(len(texts)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	search := &recordingSearch{MultiDocumentSearch: multi}
+	foo, bar, baz := Term([]byte("foo")), Term([]byte("bar")), Term([]byte("baz"))
+	assertBooleanDocuments(t, Evaluate(search, And(foo, bar, Term([]byte("zzz")), Not(baz))), "[]")
+	if fmt.Sprint(search.found) != "[zzz]" {
+		t.Errorf("Expected only the term without hits to be found but got %v", search.found)
+	}
+	search.found = nil
+	assertBooleanDocuments(t, Evaluate(search, And(Term([]byte("FOO"), IgnoreCase(true)), Term([]byte("bar ")))), "[3]")
+	if fmt.Sprint(search.found) != "[bar  FOO]" {
+		t.Errorf("Expected the terms to be found from the most selective one but got %v", search.found)
+	}
+}
+
+func TestProximityAndFilterQuery(t *testing.T) {
+	search := testSearchIn(t, "foo bar", "foo    bar", "bar baz", "bar x foo").search
+	foo, bar := Term([]byte("foo")), Term([]byte("bar"))
+	assertBooleanDocuments(t, Evaluate(search, Proximity(foo, bar, WithinBytes(3))), "[0 3]")
+	assertBooleanDocuments(t, Evaluate(search, And(bar, Not(Proximity(foo, bar, WithinBytes(3))))), "[1 2]")
+	odd := DocumentFilter(func(document *Document) bool { return document.Index%2 == 1 })
+	assertBooleanDocuments(t, Evaluate(search, And(bar, odd)), "[1 3]")
+	result := Evaluate(search, And(odd, Proximity(foo, bar, WithinBytes(5))))
+	assertBooleanDocuments(t, result, "[1 3]")
+	if fmt.Sprintf("%s", result.Terms) != "[foo bar]" {
+		t.Errorf("Unexpected positive terms %s", result.Terms)
+	}
+}
+
+func assertSpans(t *testing.T, spans []*Span, expected ...string) {
+	computed := make([]string, len(spans))
+	for i, span := range spans {
+		computed[i] = fmt.Sprintf("%v:%s", span.Document.Index, span.CharContext(0, 0).Pattern())
+	}
+	if fmt.Sprint(computed) != fmt.Sprint(expected) {
+		t.Errorf("Expected spans %q but got %q", expected, computed)
+	}
+}
+
+func TestProximity(t *testing.T) {
+	search := testSearchIn(t, "f = open(a); x(); close(f)", "close(g); open(b)", "open(c)\n\n\nclose(c)", "close(d)").search
+	open := search.Find([]byte("open("))
+	close := search.Find([]byte("close("))
+	assertSpans(t, Near(open, close, WithinBytes(200)), "0:open(a); x(); close(", "1:close(g); open(", "2:open(c)\n\n\nclose(")
+	assertSpans(t, Near(open, close, WithinBytes(4)), "1:close(g); open(")
+	assertSpans(t, Near(open, close, WithinBytes(3)))
+	assertSpans(t, Followed(open, close, WithinBytes(200)), "0:open(a); x(); close(", "2:open(c)\n\n\nclose(")
+	assertSpans(t, Followed(open, close, WithinLines(2)), "0:open(a); x(); close(")
+	assertSpans(t, Followed(open, close, WithinLines(3)), "0:open(a); x(); close(", "2:open(c)\n\n\nclose(")
+	spans := Followed(open, close, WithinLines(0))
+	if ctx := spans[0].CharContext(4, 4); string(ctx.Before()) != "f = " || string(ctx.After()) != "f)" {
+		t.Errorf("Unexpected span context %q %q", ctx.Before(), ctx.After())
+	}
+	if ctx := Near(open, close, WithinLines(5))[2].LineContext(0, 0); string(ctx.After()) != "c)" {
+		t.Errorf("Unexpected span line context %q", ctx.After())
+	}
+	assertSpans(t, Near(open, open, WithinBytes(100)))
+}
+
+func TestIgnoreCase(t *testing.T) {
+	search := testSearchIn(t, "Foo foo FOO fOo food")
+	search.findWith("foo", IgnoreCase(true)).assertPositions(0, 4, 8, 12, 16)
+	search.findWith("FOO", IgnoreCase(true), Match(WholeWord)).assertPositions(0, 4, 8, 12)
+	search.findWith("foo", IgnoreCase(false)).assertPositions(4, 16)
+	result := search.findWith("foo", IgnoreCase(true))
+	result.assertHasPosition(0, 12, true)
+	if string(result.result.Pattern()) != "foo" {
+		t.Errorf("Expected pattern foo but got %s", result.result.Pattern())
+	}
+	multi := testSearchIn(t, "Foo", "xfOO", "bar")
+	multi.findWith("foo", IgnoreCase(true)).assertPositions(0, 1)
+	if documents := fmt.Sprint(multi.findWith("FOO", IgnoreCase(true)).result.Documents()); documents != "[0 1]" {
+		t.Errorf("Expected documents [0 1] but got %v", documents)
+	}
+}
+
+func testScopes(t *testing.T, options ...IndexOption) {
+	texts := []string{"foo bar", "foo", "bar", "foo foo", "baz"}
+	ids := []string{"src/a.go", "src/b.txt", "doc/c.go", "src/d.go", "e.go"}
+	offsets, combined := combine(texts)
+	search, err := NewMulti(combined, offsets, ids, options...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scope := ScopePrefix(search, "src/")
+	if scope.DocumentCount() != 3 || scope.Document(2).Id != "src/d.go" || scope.Document(2).Index != 2 {
+		t.Errorf("Unexpected documents of the scope")
+	}
+	ts := &TestSearch{scope, t}
+	result := ts.find("foo")
+	result.assertSize(4)
+	if documents := fmt.Sprint(result.result.Documents()); documents != "[0 1 2]" {
+		t.Errorf("Expected documents [0 1 2] but got %v", documents)
+	}
+	result.assertHasPosition(2, 4, true)
+	for i := 0; i < result.result.Size(); i++ {
+		if !strings.HasPrefix(result.result.Hit(i).Document().Id, "src/") {
+			t.Errorf("Hit out of scope in document %v", result.result.Hit(i).Document().Id)
+		}
+	}
+	ts.find("bar").assertPositions(4)
+	outOfScope := search.Find([]byte("bar")).HitWithPosition(2, 0).GlobalPosition()
+	if bar := ts.find("bar").result; bar.HasGlobalPosition(outOfScope) || fmt.Sprint(bar.Positions()) != "[4]" {
+		t.Errorf("Unexpected positions of scoped hits %v", bar.Positions())
+	}
+	ts.find("baz").assertSize(0)
+	if page := result.result.Page(1, 2); len(page.Hits) != 2 || page.Hits[0].Document().Index != 1 {
+		t.Errorf("Unexpected page of scoped hits")
+	}
+	glob, err := ScopeGlob(search, "*/*.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	(&TestSearch{glob, t}).find("bar").assertSize(2)
+	assertBooleanDocuments(t, Evaluate(glob, Not(Term([]byte("foo")))), "[1]")
+	if _, err := ScopeGlob(search, "[a"); err == nil {
+		t.Errorf("Expected error for malformed glob")
+	}
+	indexScope, err := ScopeDocuments(search, 3, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	indexes := &TestSearch{indexScope, t}
+	indexes.find("foo").assertPositions(0, 4)
+	indexes.find("o").assertSize(4)
+	indexes.find("foo").assertHasPosition(0, 4, true)
+	indexScope, err = ScopeDocuments(search, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	(&TestSearch{indexScope, t}).findWith("foo", Match(WholeWord)).assertPositions(0)
+	if _, err := ScopeDocuments(search, 1, 5); !errors.Is(err, ErrDocumentIndex) {
+		t.Errorf("Expected document index error but got %v", err)
+	}
+}
+
+func TestScope(t *testing.T) {
+	testScopes(t)
+	testScopes(t, WithoutDocumentArray())
+}
+
+func assertLineColumn(t *testing.T, result SearchResult, expected ...string) {
+	computed := make([]string, result.Size())
+	for i := range computed {
+		hit := result.Hit(i)
+		computed[i] = fmt.Sprintf("%v:%v:%v:%v", hit.Document().Index, hit.Line(), hit.Column(), hit.RuneColumn())
+	}
+	sort.Strings(computed)
+	sort.Strings(expected)
+	if fmt.Sprint(computed) != fmt.Sprint(expected) {
+		t.Errorf("Expected hits at %v but got %v", expected, computed)
+	}
+}
+
+func TestLineAndColumn(t *testing.T) {
+	search := testSearchIn(t, "foo\nbar foo\r\nčaj foo\rfoo\n\nfoo")
+	assertLineColumn(t, search.find("foo").result, "0:1:1:1", "0:2:5:5", "0:3:6:5", "0:4:1:1", "0:6:1:1")
+	ctx := search.find("bar").assertSingleHit().hit.LineContext(1, 1)
+	if ctx.StartLine() != 1 || ctx.PatternLine() != 2 || ctx.EndLine() != 3 {
+		t.Errorf("Unexpected lines of context %v %v %v", ctx.StartLine(), ctx.PatternLine(), ctx.EndLine())
+	}
+	multi := testSearchIn(t, "a\nfoo", "foo\r\n\r\nxfoo")
+	assertLineColumn(t, multi.find("foo").result, "0:2:1:1", "1:1:1:1", "1:3:2:2")
+	ctx = multi.find("xfoo").assertSingleHit().hit.CharContext(2, 2)
+	if ctx.StartLine() != 2 || ctx.PatternLine() != 3 || ctx.EndLine() != 3 {
+		t.Errorf("Unexpected lines of context %v %v %v", ctx.StartLine(), ctx.PatternLine(), ctx.EndLine())
+	}
+	whitespace, err := NewWhitespaceInsensitiveSingle("doc", []byte("x\n\n  foo   bar"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertLineColumn(t, whitespace.Find([]byte("foo bar")), "0:3:3:3")
+	spans := Near(multi.find("foo").result, multi.find("xfoo").result, WithinLines(0))
+	if len(spans) != 1 || spans[0].LineContext(0, 0).StartLine() != 3 {
+		t.Errorf("Unexpected spans %v", spans)
+	}
+}
+
+func formatStructured(ctx *StructuredContext) string {
+	lines := make([]string, len(ctx.Lines))
+	for i, line := range ctx.Lines {
+		s := fmt.Sprintf("%v[%v,%v]%q", line.Number, line.Start, line.End, line.Content)
+		for _, h := range line.Highlights {
+			s += fmt.Sprintf(" %v-%v", h.Start, h.End)
+			if h.Current {
+				s += "*"
+			}
+		}
+		lines[i] = s
+	}
+	return strings.Join(lines, "; ")
+}
+
+func TestStructuredContext(t *testing.T) {
+	search := testSearchIn(t, "a foo\r\nfoo b foo\nc\rd fo", "foo\nfoo")
+	hit := search.find("foo").result.HitWithPosition(0, 7)
+	assertStructured := func(ctx *StructuredContext, expected string) {
+		if computed := formatStructured(ctx); computed != expected {
+			t.Errorf("Expected context %v but got %v", expected, computed)
+		}
+	}
+	assertStructured(hit.StructuredContext(0, 0), `2[7,16]"foo b foo" 0-3* 6-9`)
+	assertStructured(hit.StructuredContext(1, 2), `1[0,5]"a foo" 2-5; 2[7,16]"foo b foo" 0-3* 6-9; 3[17,18]"c"; 4[19,23]"d fo"`)
+	multiline := search.find("o\nf").assertSingleHit().hit
+	assertStructured(multiline.StructuredContext(0, 0), `1[0,3]"foo" 2-3*; 2[4,7]"foo" 0-1*`)
+	if doc := multiline.StructuredContext(0, 0).Document; doc.Index != 1 {
+		t.Errorf("Unexpected document %v", doc.Index)
+	}
+	words := search.findWith("foo", Match(WholeWord)).result.HitWithPosition(1, 4)
+	assertStructured(words.StructuredContext(1, 0), `1[0,3]"foo" 0-3; 2[4,7]"foo" 0-3*`)
+}
+
+func assertSnippets(t *testing.T, snippets []*Snippet, expected ...string) {
+	computed := make([]string, len(snippets))
+	for i, snippet := range snippets {
+		computed[i] = fmt.Sprintf("%v:%q", snippet.Document.Index, snippet.Content)
+		for _, h := range snippet.Highlights {
+			computed[i] += fmt.Sprintf(" %v-%v", h.Start, h.End)
+		}
+	}
+	if fmt.Sprint(computed) != fmt.Sprint(expected) {
+		t.Errorf("Expected snippets %q but got %q", expected, computed)
+	}
+}
+
+func TestSnippets(t *testing.T) {
+	search := testSearchIn(t, "ab xx ab ab yyyyyy ab", "ab\nc\nab\nd\n\nab", "zz")
+	result := search.find("ab").result
+	assertSnippets(t, Snippets(result, SnippetChars(2, 1)),
+		`0:"ab " 0-2`, `0:"x ab ab " 2-4 5-7`, `0:"y ab" 2-4`, `1:"ab\nc\nab\n" 0-2 5-7`, `1:"\n\nab" 2-4`)
+	assertSnippets(t, Snippets(result, SnippetChars(0, 0)),
+		`0:"ab" 0-2`, `0:"ab" 0-2`, `0:"ab" 0-2`, `0:"ab" 0-2`, `1:"ab" 0-2`, `1:"ab" 0-2`, `1:"ab" 0-2`)
+	assertSnippets(t, Snippets(result, SnippetChars(1, 0), MaxSnippetsPerDocument(2)),
+		`0:"ab" 0-2`, `0:" ab ab" 1-3 4-6`, `1:"ab" 0-2`, `1:"\nab" 1-3`)
+	assertSnippets(t, Snippets(result, SnippetLines(0, 1), MaxSnippetsPerDocument(1)),
+		`0:"ab xx ab ab yyyyyy ab" 0-2 6-8 9-11 19-21`, `1:"ab\nc\nab\nd" 0-2 5-7`)
+	assertSnippets(t, Snippets(search.find("zz").result, SnippetChars(5, 5)), `2:"zz" 0-2`)
+	assertSnippets(t, Snippets(search.find("qq").result))
+}
+
+func assertContext(t *testing.T, ctx HitContext, expected string) {
+	computed := fmt.Sprintf("%q|%q|%q", ctx.Before(), ctx.Pattern(), ctx.After())
+	if computed != expected {
+		t.Errorf("Expected context %v but got %v", expected, computed)
+	}
+}
+
+func TestRuneContext(t *testing.T) {
+	search := testSearchIn(t, "žluťoučký kůň úpěl")
+	hit := search.find("kůň").assertSingleHit().hit
+	assertContext(t, hit.CharContext(3, 1), `"ý "|"kůň"|" "`)
+	assertContext(t, hit.CharContext(2, 3), `"\xbd "|"kůň"|" ú"`)
+	assertContext(t, hit.RuneContext(4, 2), `"čký "|"kůň"|" ú"`)
+	assertContext(t, hit.RuneContext(0, 0), `""|"kůň"|""`)
+	assertContext(t, hit.RuneContext(100, 100), `"žluťoučký "|"kůň"|" úpěl"`)
+	binary := testSearchIn(t, "\xff\xfe\xc5abc\xe2\x82", "\x00\x01ab")
+	assertContext(t, binary.find("ab").result.HitWithPosition(0, 3).RuneContext(2, 2), `"\xfe\xc5"|"ab"|"c\xe2"`)
+	assertContext(t, binary.find("ab").result.HitWithPosition(1, 2).RuneContext(5, 5), `"\x00\x01"|"ab"|""`)
+	multi := testSearchIn(t, "čaj", "ďas")
+	assertContext(t, multi.find("as").assertSingleHit().hit.RuneContext(3, 3), `"ď"|"as"|""`)
+	spans := Near(multi.find("a").result, multi.find("j").result, WithinBytes(0))
+	assertContext(t, spans[0].RuneContext(1, 1), `"č"|"aj"|""`)
+}
+
+func assertTruncation(t *testing.T, ctx HitContext, before, after bool) {
+	if ctx.TruncatedBefore() != before || ctx.TruncatedAfter() != after {
+		t.Errorf("Expected truncation %v %v but got %v %v", before, after, ctx.TruncatedBefore(), ctx.TruncatedAfter())
+	}
+}
+
+func TestBoundedLineContext(t *testing.T) {
+	long := strings.Repeat("a", 100) + "foo" + strings.Repeat("b", 100)
+	search := testSearchIn(t, "x\n"+long+"\ny", "short foo\nline", long)
+	hit := search.find("foo").result.HitWithPosition(0, 102)
+	ctx := hit.BoundedLineContext(0, 0, 13)
+	assertContext(t, ctx, `"aaaaa"|"foo"|"bbbbb"`)
+	assertTruncation(t, ctx, true, true)
+	assertContext(t, hit.BoundedLineContext(5, 5, 2), `""|"foo"|""`)
+	short := search.find("foo").result.HitWithPosition(1, 6)
+	ctx = short.BoundedLineContext(0, 1, 100)
+	assertContext(t, ctx, fmt.Sprintf("%q|%q|%q", short.LineContext(0, 1).Before(), "foo", short.LineContext(0, 1).After()))
+	assertTruncation(t, ctx, false, false)
+	ctx = short.BoundedLineContext(0, 1, 10)
+	assertContext(t, ctx, `"rt "|"foo"|"\nlin"`)
+	assertTruncation(t, ctx, true, true)
+	ctx = short.BoundedLineContext(0, 0, 10)
+	assertContext(t, ctx, `"short "|"foo"|""`)
+	assertTruncation(t, ctx, false, false)
+	edge := search.find("foo").result.HitWithPosition(2, 100)
+	ctx = edge.BoundedLineContext(0, 0, 1000)
+	assertTruncation(t, ctx, false, false)
+	if len(ctx.Before()) != 100 || len(ctx.After()) != 100 {
+		t.Errorf("Expected whole line but got %v %v", len(ctx.Before()), len(ctx.After()))
+	}
+	structured := hit.BoundedStructuredContext(1, 1, 21)
+	if computed := formatStructured(structured); computed != `2[93,114]"aaaaaaaaafoobbbbbbbbb" 9-12*` {
+		t.Errorf("Unexpected structured context %v", computed)
+	}
+	if line := structured.Lines[0]; !line.TruncatedStart || !line.TruncatedEnd {
+		t.Errorf("Expected truncated line")
+	}
+	utf := testSearchIn(t, "ččččfooďďďď").find("foo").assertSingleHit().hit
+	assertContext(t, utf.BoundedLineContext(0, 0, 8), `"č"|"foo"|"ď"`)
+	longPattern := search.find("aafoobb").result.HitWithPosition(0, 100)
+	ctx = longPattern.BoundedLineContext(1, 1, 4)
+	assertContext(t, ctx, `""|"aafoobb"|""`)
+	assertTruncation(t, ctx, true, true)
+	if lines := longPattern.BoundedStructuredContext(1, 1, 4).Lines; len(lines) != 1 || string(lines[0].Content) != "aafoobb" {
+		t.Errorf("Expected just the pattern in the structured context but got %v", lines)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	search := testSearchIn(t, "foo bar", "baz").search
+	if _, err := TryFind(search, nil); err != ErrEmptyPattern {
+		t.Errorf("Expected empty pattern error but got %v", err)
+	}
+	result, err := TryFind(search, []byte("ba"))
+	if err != nil || result.Size() != 2 {
+		t.Errorf("Unexpected result %v %v", result, err)
+	}
+	if _, err := TryDocument(search, 2); !errors.Is(err, ErrDocumentIndex) {
+		t.Errorf("Expected document index error but got %v", err)
+	}
+	if _, err := TryHit(result, 2); !errors.Is(err, ErrHitIndex) {
+		t.Errorf("Expected hit index error but got %v", err)
+	}
+	if _, err := TryHit(EmptySearchResult("x"), 0); !errors.Is(err, ErrHitIndex) {
+		t.Errorf("Expected hit index error but got %v", err)
+	}
+	hit, _ := TryHit(result, 1)
+	if _, err := TryCharContext(hit, -1, 0); err != ErrNegativeContext {
+		t.Errorf("Expected negative context error but got %v", err)
+	}
+	if _, err := TryLineContext(hit, 0, -1); err != ErrNegativeContext {
+		t.Errorf("Expected negative context error but got %v", err)
+	}
+	if ctx, err := TryLineContext(hit, 0, 0); err != nil || len(ctx.Pattern()) != 2 {
+		t.Errorf("Unexpected context %v %v", ctx, err)
+	}
+	if _, err := TryFindAll(search.(BatchSearch), [][]byte{[]byte("ba"), nil}); !errors.Is(err, ErrEmptyPattern) {
+		t.Errorf("Expected empty pattern error but got %v", err)
+	}
+	if results, err := TryFindAll(search.(BatchSearch), [][]byte{[]byte("ba")}); err != nil || results[0].Size() != 2 {
+		t.Errorf("Unexpected results %v %v", results, err)
+	}
+	if _, err := TryMaxHits(-1); !errors.Is(err, ErrNegativeLimit) {
+		t.Errorf("Expected negative limit error but got %v", err)
+	}
+	for _, r := range []SearchResult{result, EmptySearchResult("x")} {
+		if _, err := TryPage(r, -1, 1); !errors.Is(err, ErrNegativeLimit) {
+			t.Errorf("Expected negative limit error but got %v", err)
+		}
+		if _, err := TryPage(r, 0, -1); !errors.Is(err, ErrNegativeLimit) {
+			t.Errorf("Expected negative limit error but got %v", err)
+		}
+		if _, err := r.PageAfter("", -1); !errors.Is(err, ErrNegativeLimit) {
+			t.Errorf("Expected negative limit error but got %v", err)
+		}
+	}
+	if _, err := result.PageAfter("x", 1); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("Expected invalid cursor error but got %v", err)
+	}
+	if page, err := TryPage(result, 1, 1); err != nil || len(page.Hits) != 1 {
+		t.Errorf("Unexpected page %v %v", page, err)
+	}
+	recovered := func(f func()) (err error) {
+		defer Recover(&err)
+		f()
+		return nil
+	}
+	if err := recovered(func() { EmptySearchResult("x").Hit(0).Position() }); !errors.Is(err, ErrHitIndex) {
+		t.Errorf("Expected recovered hit index error but got %v", err)
+	}
+	if err := recovered(func() { search.Document(5) }); !errors.Is(err, ErrDocumentIndex) {
+		t.Errorf("Expected recovered document index error but got %v", err)
+	}
+	if err := recovered(func() { search.Find(nil) }); err != ErrEmptyPattern {
+		t.Errorf("Expected recovered empty pattern error but got %v", err)
+	}
+	whitespace, err := NewWhitespaceInsensitiveSingle("a", []byte("foo  bar"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	scope, err := ScopeDocuments(search, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []struct {
+		f      func()
+		target error
+	}{
+		{func() { MaxHits(-1) }, ErrNegativeLimit},
+		{func() { result.Page(-1, 1) }, ErrNegativeLimit},
+		{func() { EmptySearchResult("x").Page(0, -1) }, ErrNegativeLimit},
+		{func() { Proximity(Not(Term([]byte("foo"))), Term([]byte("bar")), WithinBytes(1)) }, ErrInvalidQuery},
+		{func() { scope.Document(1) }, ErrDocumentIndex},
+		{func() { scope.UnderlyingIndex(-1) }, ErrDocumentIndex},
+		{func() { whitespace.Document(1) }, ErrDocumentIndex},
+	}
+	for i, e := range expected {
+		if err := recovered(e.f); !errors.Is(err, e.target) {
+			t.Errorf("Expected recovered error %v of call %v but got %v", e.target, i, err)
+		}
+	}
+}
+
+// Summary of everything lazily cached by the result
+func describeResult(result SearchResult) string {
+	var b strings.Builder
+	for _, doc := range result.Documents() {
+		for _, hit := range result.DocumentHits(doc) {
+			fmt.Fprintf(&b, "%v:%v:%v:%v,%v ", doc, hit.Position(), hit.Line(), hit.Column(),
+				result.HasPosition(doc, hit.Position()))
+		}
+	}
+	return b.String()
+}
+
+// Caches of the search and the shared results are first built by the concurrent readers
+func testConcurrentReaders(t *testing.T, newSearch func() Search) {
+	patterns := []string{"a", "ab", "b\nc", "c"}
+	expected := make([]string, len(patterns))
+	for i, pattern := range patterns {
+		expected[i] = describeResult(newSearch().Find([]byte(pattern)))
+	}
+	search := newSearch()
+	shared := make([]SearchResult, len(patterns))
+	for i, pattern := range patterns {
+		shared[i] = search.Find([]byte(pattern))
+	}
+	var wg sync.WaitGroup
+	for g := 0; g < 16; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := range patterns {
+				i = (i + g) % len(patterns)
+				if r := describeResult(shared[i]); r != expected[i] {
+					t.Errorf("Shared result of %q: expected %v but got %v", patterns[i], expected[i], r)
+				}
+				if r := describeResult(search.Find([]byte(patterns[i]))); r != expected[i] {
+					t.Errorf("Result of %q: expected %v but got %v", patterns[i], expected[i], r)
+				}
+				Evaluate(search, And(Term([]byte("a")), Not(Term([]byte("c")))))
+			}
+		}(g)
+	}
+	wg.Wait()
+}
+
+func TestConcurrentReaders(t *testing.T) {
+	texts := []string{"ab\ncab", "b\nca a", "ccc", "a\nb\nc\nab"}
+	offsets, combined := combine(texts)
+	must := func(search Search, err error) Search {
+		if err != nil {
+			t.Fatal(err)
+		}
+		return search
+	}
+	for _, options := range [][]IndexOption{nil, {WithoutDocumentArray()}} {
+		testConcurrentReaders(t, func() Search {
+			return must(NewMulti(combined, offsets, testIds(len(texts)), options...))
+		})
+		testConcurrentReaders(t, func() Search {
+			return must(ScopeDocuments(must(NewMulti(combined, offsets, testIds(len(texts)), options...)), 0, 1, 3))
+		})
+	}
+	testConcurrentReaders(t, func() Search {
+		return NewCachingSearch(must(NewMulti(combined, offsets, testIds(len(texts)))), 1<<20)
+	})
+	testConcurrentReaders(t, func() Search {
+		return must(NewWhitespaceInsensitiveMulti(combined, offsets, testIds(len(texts))))
+	})
+	testConcurrentReaders(t, func() Search {
+		return must(NewSingle("testDoc", []byte(texts[3])))
+	})
+}
+
+func TestForEachHit(t *testing.T) {
+	search := testSearchIn(t, "banana", "ananas", "cabana")
+	result := search.find("an").result
+	var hits []Hit
+	result.ForEachHit(func(hit Hit) bool {
+		hits = append(hits, hit)
+		return true
+	})
+	positions := hitPositions(hits)
+	sort.Strings(positions)
+	if fmt.Sprint(positions) != "[0:1 0:3 1:0 1:2 2:3]" {
+		t.Errorf("Unexpected hits %v", positions)
+	}
+	count := 0
+	result.ForEachHit(func(hit Hit) bool {
+		count++
+		return count < 2
+	})
+	if count != 2 {
+		t.Errorf("Expected iteration to stop after 2 hits but got %v", count)
+	}
+	EmptySearchResult("x").ForEachHit(func(hit Hit) bool {
+		t.Errorf("Unexpected hit of empty result")
+		return true
+	})
+}
+
+func TestMaxHits(t *testing.T) {
+	texts := []string{"banana", "ananas", "cabana", "an an"}
+	offsets, combined := combine(texts)
+	multi, err := NewMulti(combined, offsets, testIds(len(texts)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	whitespace, err := NewWhitespaceInsensitiveMulti(combined, offsets, testIds(len(texts)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertLimited := func(result SearchResult, total int, expected ...string) {
+		if result.Total() != total {
+			t.Errorf("Expected total %v but got %v", total, result.Total())
+		}
+		hits := make([]Hit, 0)
+		result.ForEachHit(func(hit Hit) bool {
+			hits = append(hits, hit)
+			return true
+		})
+		positions := hitPositions(hits)
+		sort.Strings(positions)
+		if fmt.Sprint(positions) != fmt.Sprint(expected) {
+			t.Errorf("Expected hits %v but got %v", expected, positions)
+		}
+		if result.Size() != len(expected) {
+			t.Errorf("Expected size %v but got %v", len(expected), result.Size())
+		}
+	}
+	assertLimited(multi.Find([]byte("an"), MaxHits(3)), 7, "2:3", "3:0", "3:3")
+	assertLimited(multi.Find([]byte("an"), MaxHits(7)), 7, "0:1", "0:3", "1:0", "1:2", "2:3", "3:0", "3:3")
+	assertLimited(multi.Find([]byte("an"), MaxHits(0)), 7, "0:1", "0:3", "1:0", "1:2", "2:3", "3:0", "3:3")
+	assertLimited(multi.Find([]byte("AN"), IgnoreCase(true), MaxHits(2)), 7, "3:0", "3:3")
+	assertLimited(multi.Find([]byte("an"), Match(WholeWord), MaxHits(1)), 2, "3:3")
+	scope, err := ScopeDocuments(multi, 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertLimited(scope.Find([]byte("an"), MaxHits(2)), 3, "0:0", "1:3")
+	assertLimited(whitespace.Find([]byte("n  a"), MaxHits(1)), 1, "3:1")
+	assertLimited(whitespace.Find([]byte("a"), MaxHits(1)), 11, "2:5")
+	limited := multi.Find([]byte("an"), MaxHits(2))
+	if documents := fmt.Sprint(limited.Documents()); documents != "[3]" {
+		t.Errorf("Expected documents [3] of limited result but got %v", documents)
+	}
+	if !limited.HasPosition(3, 3) || limited.HasPosition(2, 3) {
+		t.Errorf("Unexpected hits of limited result %v", limited.Positions())
+	}
+	unlimited := multi.Find([]byte("an"))
+	for i := 0; i < limited.Size(); i++ {
+		if limited.Hit(i).GlobalPosition() != unlimited.Hit(i).GlobalPosition() {
+			t.Errorf("Expected hit %v of limited result to be hit %v of unlimited one", i, i)
+		}
+	}
+}
+
+func TestCachedResultBytesBoundLazyCaches(t *testing.T) {
+	texts := make([]string, 5000)
+	for i := range texts {
+		texts[i] = fmt.Sprintf("a %v aa\nc ab", i)
+	}
+	offsets, combined := combine(texts)
+	multi, err := NewMulti(combined, offsets, testIds(len(texts)), WithoutDocumentArray())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	result := multi.Find([]byte("a"), Match(WholeWord))
+	result.Documents()
+	result.HitWithPosition(5, 0).LineContext(1, 1)
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+	used := int64(after.HeapAlloc) - int64(before.HeapAlloc)
+	if estimate := resultBytes([]byte("a"), result); used > estimate {
+		t.Errorf("Result with built caches uses %v bytes, more than the estimate %v", used, estimate)
+	}
+	runtime.KeepAlive(result)
+}
+
+func TestCachingSearch(t *testing.T) {
+	texts := []string{"banana", "ananas", "cabana"}
+	offsets, combined := combine(texts)
+	multi, err := NewMulti(combined, offsets, testIds(len(texts)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertStats := func(cache *CachingSearch, hits, misses, evictions int64, entries int) {
+		stats := cache.Stats()
+		if stats.Hits != hits || stats.Misses != misses || stats.Evictions != evictions || stats.Entries != entries {
+			t.Errorf("Expected %v hits, %v misses, %v evictions and %v entries but got %+v", hits, misses, evictions, entries, stats)
+		}
+	}
+	cache := NewCachingSearch(multi, 1<<20)
+	first := cache.Find([]byte("an"))
+	if cache.Find([]byte("an")) != first {
+		t.Errorf("Expected the cached result")
+	}
+	if cache.Find([]byte("an"), IgnoreCase(true)) == first || cache.Find([]byte("an"), MaxHits(1)).Size() != 1 {
+		t.Errorf("Expected results of different options to be cached separately")
+	}
+	assertStats(cache, 1, 3, 0, 3)
+	if cache.Find([]byte("an"), MaxHits(1)).Total() != 5 {
+		t.Errorf("Expected the cached limited result")
+	}
+	assertStats(cache, 2, 3, 0, 3)
+
+	if limited := cache.Find([]byte("an"), MaxHits(1)); resultBytes([]byte("an"), limited) != resultBytes([]byte("an"), first)+cachedHitBytes {
+		t.Errorf("Expected the limited result to be charged for the hits of the result it limits")
+	}
+	size := resultBytes([]byte("an"), first)
+	cache = NewCachingSearch(multi, 2*size)
+	cache.Find([]byte("an"))
+	cache.Find([]byte("na"))
+	cache.Find([]byte("an"))
+	cache.Find([]byte("ba"))
+	assertStats(cache, 1, 3, 1, 2)
+	cache.Find([]byte("an"))
+	cache.Find([]byte("na"))
+	assertStats(cache, 2, 4, 2, 2)
+	if stats := cache.Stats(); stats.Bytes > 2*size {
+		t.Errorf("Expected at most %v bytes cached but got %v", 2*size, stats.Bytes)
+	}
+	small := NewCachingSearch(multi, size)
+	small.Find([]byte("a"))
+	assertStats(small, 0, 1, 0, 0)
+
+	replacement, err := NewSingle("other", []byte("an ant"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.Replace(replacement)
+	assertStats(cache, 2, 4, 2, 0)
+	if result := cache.Find([]byte("an")); result.Size() != 2 || cache.DocumentCount() != 1 || cache.Document(0).Id != "other" {
+		t.Errorf("Expected results of the replacement search")
+	}
+	cache.Invalidate()
+	cache.Find([]byte("an"))
+	assertStats(cache, 2, 6, 2, 1)
+}
+
+func TestLineIndexOnDemand(t *testing.T) {
+	offsets, combined := combine([]string{"foo bar\nfoo", "bar\nfoo"})
+	search, err := NewMulti(combined, offsets, testIds(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	built := func() string {
+		r := make([]bool, len(search.lines.indexes))
+		for i, index := range search.lines.indexes {
+			r[i] = index != nil
+		}
+		return fmt.Sprint(r)
+	}
+	result := search.Find([]byte("foo"), Match(WholeWord))
+	hit := result.HitWithPosition(1, 4)
+	ctx := hit.LineContext(1, 0)
+	if string(ctx.Before()) != "bar\n" || built() != "[false false]" {
+		t.Errorf("Expected no line index built for contexts, built %v", built())
+	}
+	if ctx.StartLine() != 1 || ctx.PatternLine() != 2 || built() != "[false true]" {
+		t.Errorf("Expected line index of the document of the hit only, built %v", built())
+	}
+}
+
+func TestStructuredContextVisitsNearbyHits(t *testing.T) {
+	search := testSearchIn(t, strings.Repeat("ab\n", 5000))
+	hit := search.find("ab").result.HitWithPosition(0, 7500)
+	if ctx := hit.StructuredContext(1, 1); formatStructured(ctx) != formatStructured(hit.StructuredContext(1, 1)) || len(ctx.Lines) != 3 {
+		t.Fatalf("Unexpected context %v", formatStructured(ctx))
+	}
+	allocs := testing.AllocsPerRun(10, func() {
+		hit.StructuredContext(1, 1)
+	})
+	if allocs > 50 {
+		t.Errorf("Expected allocations for the hits near the context only but got %v", allocs)
+	}
+}
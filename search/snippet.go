@@ -0,0 +1,97 @@
+// Snippets merging contexts of nearby hits
+package search
+
+type SnippetOptions struct {
+	Before, After  int  // Size of the context of each hit
+	Lines          bool // Context size is given in lines instead of bytes
+	MaxPerDocument int  // Maximum number of snippets of each document, 0 for no limit
+}
+
+type SnippetOption func(*SnippetOptions)
+
+// Context of each hit given as number of bytes
+func SnippetChars(before, after int) SnippetOption {
+	return func(options *SnippetOptions) {
+		options.Before, options.After, options.Lines = before, after, false
+	}
+}
+
+// Context of each hit given as number of lines
+func SnippetLines(before, after int) SnippetOption {
+	return func(options *SnippetOptions) {
+		options.Before, options.After, options.Lines = before, after, true
+	}
+}
+
+func MaxSnippetsPerDocument(n int) SnippetOption {
+	return func(options *SnippetOptions) {
+		options.MaxPerDocument = n
+	}
+}
+
+// Part of a document covering the contexts of one or more hits
+type Snippet struct {
+	Document   *Document
+	Start, End int // byte range in the document
+	Content    []byte
+	Hits       []Hit       // sorted by position
+	Highlights []Highlight // the hits relative to Start, all of them marked as current
+}
+
+func (this *SnippetOptions) context(hit Hit) HitContext {
+	h := hit.(*HitStruct)
+	if this.Lines {
+		return h.searchResult.lineContext(h.hitIdx, this.Before, this.After)
+	}
+	return h.searchResult.charContext(h.hitIdx, this.Before, this.After)
+}
+
+// Contexts in lines are also adjacent when just a line break separates them
+func (this *SnippetOptions) adjacent(last *Snippet, start int32) bool {
+	end := int32(last.End)
+	if start <= end {
+		return true
+	}
+	return this.Lines && isNewLine(last.Document.Content, end) == start-end
+}
+
+// Groups hits of the result by document and merges their overlapping or adjacent contexts
+// into snippets. The snippets are ordered by document and position.
+func Snippets(result SearchResult, options ...SnippetOption) []*Snippet {
+	snippetOptions := new(SnippetOptions)
+	for _, option := range options {
+		option(snippetOptions)
+	}
+	if snippetOptions.Before < 0 || snippetOptions.After < 0 {
+		panic(ErrNegativeContext)
+	}
+	r := make([]*Snippet, 0)
+	for _, doc := range result.Documents() {
+		var last *Snippet
+		count := 0
+		for _, hit := range result.DocumentHits(doc) {
+			start, end := snippetOptions.context(hit).documentRange()
+			if last != nil && snippetOptions.adjacent(last, start) {
+				if int(end) > last.End {
+					last.End = int(end)
+				}
+				last.Hits = append(last.Hits, hit)
+				continue
+			}
+			if snippetOptions.MaxPerDocument > 0 && count == snippetOptions.MaxPerDocument {
+				break
+			}
+			last = &Snippet{Document: hit.Document(), Start: int(start), End: int(end), Hits: []Hit{hit}}
+			r = append(r, last)
+			count++
+		}
+	}
+	for _, snippet := range r {
+		snippet.Content = snippet.Document.Content[snippet.Start:snippet.End]
+		snippet.Highlights = make([]Highlight, len(snippet.Hits))
+		for i, hit := range snippet.Hits {
+			snippet.Highlights[i] = Highlight{hit.Position() - snippet.Start, hitEnd(hit) - snippet.Start, true}
+		}
+	}
+	return r
+}
@@ -0,0 +1,108 @@
+// Structured line by line context of hits
+package search
+
+import (
+	"sort"
+)
+
+// Highlighted part of a context line
+type Highlight struct {
+	Start, End int  // byte range relative to the start of the line
+	Current    bool // part of the hit the context was created for
+}
+
+type ContextLine struct {
+	Number         int // the first line of the document is 1
+	Start, End     int // byte range of the line in the document excluding the line break
+	Content        []byte
+	Highlights     []Highlight // hits of the pattern in the line sorted by start
+	TruncatedStart bool        // the line is cut at the start due to a size limit, show ellipsis
+	TruncatedEnd   bool        // the line is cut at the end due to a size limit
+}
+
+// Lines of the document around a hit with all hits of the pattern in them highlighted
+type StructuredContext struct {
+	Document *Document
+	Lines    []ContextLine
+}
+
+// Removes the line break from the end of the line
+func trimLineBreak(content []byte, start, end int32) int32 {
+	if end > start && content[end-1] == '\n' {
+		end--
+	}
+	if end > start && content[end-1] == '\r' {
+		end--
+	}
+	return end
+}
+
+// Hits of the result in the document of the hit overlapping [start, end) as ranges in the
+// document. They are looked up in the position index by binary search, so only the hits near
+// the range are visited.
+func hitsInRange(result SearchResult, hitIdx int, start, end int32) []hitBounds {
+	offset := result.globalPosition(hitIdx) - result.position(hitIdx)
+	hits := result.positionIndex().hits
+	position := func(i int) int {
+		return result.globalPosition(int(hits[i])) - offset
+	}
+	bounds := func(i int) hitBounds {
+		h := int(hits[i])
+		pos := result.position(h)
+		return hitBounds{result.Hit(h), pos, pos + len(result.charContext(h, 0, 0).Pattern())}
+	}
+	i := sort.Search(len(hits), func(i int) bool { return position(i) >= int(start) })
+	for i > 0 && position(i-1) >= 0 && bounds(i-1).end > int(start) {
+		i--
+	}
+	r := make([]hitBounds, 0)
+	for ; i < len(hits) && position(i) < int(end); i++ {
+		if b := bounds(i); b.end > int(start) {
+			r = append(r, b)
+		}
+	}
+	return r
+}
+
+func structuredContext(result SearchResult, hitIdx int, ctx HitContext) *StructuredContext {
+	document := result.document(hitIdx)
+	lines := result.lineIndex(hitIdx)
+	content := document.Content
+	start, end := ctx.documentRange()
+	current := int32(result.position(hitIdx))
+	hits := hitsInRange(result, hitIdx, start, end)
+	r := &StructuredContext{document, make([]ContextLine, 0)}
+	last := lines.line(start)
+	if end > start {
+		last = lines.line(end - 1)
+	}
+	for number := lines.line(start); number <= last; number++ {
+		lineStart, lineEnd := lines.lineStart(number), int32(len(content))
+		if number < len(lines.starts) {
+			lineEnd = lines.lineStart(number + 1)
+		}
+		lineEnd = trimLineBreak(content, lineStart, lineEnd)
+		truncatedStart, truncatedEnd := lineStart < start, lineEnd > end
+		lineStart, lineEnd = max32(lineStart, start), min32(lineEnd, end)
+		lineEnd = max32(lineStart, lineEnd)
+		line := ContextLine{number, int(lineStart), int(lineEnd), content[lineStart:lineEnd], make([]Highlight, 0),
+			truncatedStart, truncatedEnd}
+		for _, hit := range hits {
+			hitStart, hitEnd := max32(int32(hit.start), lineStart), min32(int32(hit.end), lineEnd)
+			if hitStart < hitEnd {
+				line.Highlights = append(line.Highlights,
+					Highlight{int(hitStart - lineStart), int(hitEnd - lineStart), int32(hit.start) == current})
+			}
+		}
+		r.Lines = append(r.Lines, line)
+	}
+	return r
+}
+
+func min32(a, b int32) int32 {
+	return ifelse(a < b, a, b)
+}
+
+func max32(a, b int32) int32 {
+	return ifelse(a > b, a, b)
+}
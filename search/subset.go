@@ -0,0 +1,140 @@
+// Search result restricted to a subset of hits of another search result
+package search
+
+type SubsetSearchResult struct {
+	result   SearchResult
+	hits     []int32 // indexes of the selected hits in result, in the order of result
+	total    int     // number of hits before the MaxHits limit was applied
+	posIndex lazyPositionIndex
+}
+
+// Creates result containing only the hits of result for which accept returns true
+func newSubsetSearchResult(result SearchResult, accept func(hitIdx int) bool) SearchResult {
+	hits := make([]int32, 0)
+	for i := 0; i < result.Size(); i++ {
+		if accept(i) {
+			hits = append(hits, int32(i))
+		}
+	}
+	if len(hits) == 0 {
+		return EmptySearchResult(result.Pattern())
+	}
+	if len(hits) == result.Size() {
+		return result
+	}
+	return &SubsetSearchResult{result: result, hits: hits, total: len(hits)}
+}
+
+// Keeps only the first limit hits of result in the order of hit indexes, so that no hit past
+// the limit has to be visited
+func newLimitedSearchResult(result SearchResult, limit int) SearchResult {
+	hits := make([]int32, limit)
+	for i := range hits {
+		hits[i] = int32(i)
+	}
+	return &SubsetSearchResult{result: result, hits: hits, total: result.Total()}
+}
+
+func (this *SubsetSearchResult) IsEmpty() bool {
+	return false
+}
+
+func (this *SubsetSearchResult) Size() int {
+	return len(this.hits)
+}
+
+func (this *SubsetSearchResult) Hit(hitIdx int) Hit {
+	return &HitStruct{this, hitIdx}
+}
+
+func (this *SubsetSearchResult) PatternLength() int {
+	return this.result.PatternLength()
+}
+
+func (this *SubsetSearchResult) Pattern() []byte {
+	return this.result.Pattern()
+}
+
+func (this *SubsetSearchResult) positionIndex() *positionIndex {
+	return this.posIndex.get(this)
+}
+
+func (this *SubsetSearchResult) heldHits() int {
+	return this.Size() + this.result.heldHits()
+}
+
+func (this *SubsetSearchResult) HasGlobalPosition(position int) bool {
+	return this.positionIndex().findGlobal(this, position) != -1
+}
+
+func (this *SubsetSearchResult) HitWithGlobalPosition(position int) Hit {
+	return hitOrNil(this, this.positionIndex().findGlobal(this, position))
+}
+
+func (this *SubsetSearchResult) HasPosition(document, position int) bool {
+	return this.positionIndex().find(this, document, position) != -1
+}
+
+func (this *SubsetSearchResult) HitWithPosition(document, position int) Hit {
+	return hitOrNil(this, this.positionIndex().find(this, document, position))
+}
+
+func (this *SubsetSearchResult) Positions() []int {
+	r := make([]int, this.Size())
+	for i := range r {
+		r[i] = this.position(i)
+	}
+	return r
+}
+
+func (this *SubsetSearchResult) Total() int {
+	return this.total
+}
+
+func (this *SubsetSearchResult) ForEachHit(f func(hit Hit) bool) {
+	forEachHit(this, f)
+}
+
+func (this *SubsetSearchResult) Page(offset, limit int) *HitPage {
+	return page(this, offset, limit)
+}
+
+func (this *SubsetSearchResult) PageAfter(cursor string, limit int) (*HitPage, error) {
+	return pageAfter(this, cursor, limit)
+}
+
+func (this *SubsetSearchResult) Documents() []int {
+	return this.positionIndex().distinctDocuments(this)
+}
+
+func (this *SubsetSearchResult) DocumentHits(document int) []Hit {
+	return this.positionIndex().documentHits(this, document)
+}
+
+func (this *SubsetSearchResult) document(hitIdx int) *Document {
+	return this.result.document(int(this.hits[hitIdx]))
+}
+
+func (this *SubsetSearchResult) documentIndex(hitIdx int) int {
+	return this.result.documentIndex(int(this.hits[hitIdx]))
+}
+
+func (this *SubsetSearchResult) globalPosition(hitIdx int) int {
+	return this.result.globalPosition(int(this.hits[hitIdx]))
+}
+
+func (this *SubsetSearchResult) position(hitIdx int) int {
+	return this.result.position(int(this.hits[hitIdx]))
+}
+
+func (this *SubsetSearchResult) charContext(hitIndex int, charsBefore, charsAfter int) HitContext {
+	return this.result.charContext(int(this.hits[hitIndex]), charsBefore, charsAfter)
+}
+
+func (this *SubsetSearchResult) lineContext(hitIndex int, linesBefore, linesAfter int) HitContext {
+	return this.result.lineContext(int(this.hits[hitIndex]), linesBefore, linesAfter)
+}
+
+func (this *SubsetSearchResult) lineIndex(hitIndex int) *lineIndex {
+	return this.result.lineIndex(int(this.hits[hitIndex]))
+}
@@ -0,0 +1,49 @@
+// Top-k documents by term frequency
+package search
+
+import (
+	"sort"
+)
+
+type DocumentFrequency struct {
+	Document int // Index of the document
+	Count    int // Number of occurrences of the pattern in the document
+}
+
+// Returns at most k documents with the most occurrences of the pattern, ordered by the number
+// of occurrences descending, then by document index. With the document array it doesn't need
+// to look at every hit of a frequent pattern.
+func (search *MultiDocumentSearch) TopDocuments(pattern []byte, k int) []DocumentFrequency {
+	interval := search.esa.Find(pattern, search.separatorAwareMatch)
+	if interval == nil || k <= 0 {
+		return make([]DocumentFrequency, 0)
+	}
+	if search.esa.Documents == nil {
+		return topDocumentsNaive(search.newResult(pattern, interval, new(FindOptions)), k)
+	}
+	docs, counts := search.esa.Documents.TopK(interval.Start, interval.End, k)
+	r := make([]DocumentFrequency, len(docs))
+	for i := range docs {
+		r[i] = DocumentFrequency{int(docs[i]), int(counts[i])}
+	}
+	return r
+}
+
+// Counts the hits of each document
+func topDocumentsNaive(result SearchResult, k int) []DocumentFrequency {
+	counts := make(map[int]int)
+	for i := 0; i < result.Size(); i++ {
+		counts[result.documentIndex(i)]++
+	}
+	r := make([]DocumentFrequency, 0, len(counts))
+	for doc, count := range counts {
+		r = append(r, DocumentFrequency{doc, count})
+	}
+	sort.Slice(r, func(i, j int) bool {
+		return r[i].Count > r[j].Count || (r[i].Count == r[j].Count && r[i].Document < r[j].Document)
+	})
+	if len(r) > k {
+		r = r[:k]
+	}
+	return r
+}
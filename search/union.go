@@ -0,0 +1,152 @@
+// Search result combining results of several patterns
+package search
+
+import (
+	"sort"
+)
+
+// Union of results of patterns of the same length, such as the case variants of a pattern
+type UnionSearchResult struct {
+	pattern  []byte
+	results  []SearchResult
+	starts   []int // hits of results[i] have indexes starts[i], ..., starts[i+1]-1 in the union
+	posIndex lazyPositionIndex
+}
+
+func newUnionSearchResult(pattern []byte, results []SearchResult) SearchResult {
+	r := &UnionSearchResult{pattern: pattern, starts: []int{0}}
+	for _, result := range results {
+		if !result.IsEmpty() {
+			r.results = append(r.results, result)
+			r.starts = append(r.starts, r.starts[len(r.starts)-1]+result.Size())
+		}
+	}
+	if len(r.results) == 0 {
+		return EmptySearchResult(pattern)
+	}
+	return r
+}
+
+// Returns the result containing the hit and index of the hit in it
+func (this *UnionSearchResult) locate(hitIdx int) (SearchResult, int) {
+	i := sort.SearchInts(this.starts, hitIdx+1) - 1
+	if i < 0 || i >= len(this.results) {
+		return EmptySearchResult(this.pattern), hitIdx
+	}
+	return this.results[i], hitIdx - this.starts[i]
+}
+
+func (this *UnionSearchResult) IsEmpty() bool {
+	return false
+}
+
+func (this *UnionSearchResult) Size() int {
+	return this.starts[len(this.starts)-1]
+}
+
+func (this *UnionSearchResult) Hit(hitIdx int) Hit {
+	return &HitStruct{this, hitIdx}
+}
+
+func (this *UnionSearchResult) PatternLength() int {
+	return len(this.pattern)
+}
+
+func (this *UnionSearchResult) Pattern() []byte {
+	return this.pattern
+}
+
+func (this *UnionSearchResult) positionIndex() *positionIndex {
+	return this.posIndex.get(this)
+}
+
+func (this *UnionSearchResult) heldHits() int {
+	r := this.Size()
+	for _, result := range this.results {
+		r += result.heldHits()
+	}
+	return r
+}
+
+func (this *UnionSearchResult) HasGlobalPosition(position int) bool {
+	return this.positionIndex().findGlobal(this, position) != -1
+}
+
+func (this *UnionSearchResult) HitWithGlobalPosition(position int) Hit {
+	return hitOrNil(this, this.positionIndex().findGlobal(this, position))
+}
+
+func (this *UnionSearchResult) HasPosition(document, position int) bool {
+	return this.positionIndex().find(this, document, position) != -1
+}
+
+func (this *UnionSearchResult) HitWithPosition(document, position int) Hit {
+	return hitOrNil(this, this.positionIndex().find(this, document, position))
+}
+
+func (this *UnionSearchResult) Positions() []int {
+	r := make([]int, this.Size())
+	for i := range r {
+		r[i] = this.position(i)
+	}
+	return r
+}
+
+func (this *UnionSearchResult) Total() int {
+	return this.Size()
+}
+
+func (this *UnionSearchResult) ForEachHit(f func(hit Hit) bool) {
+	forEachHit(this, f)
+}
+
+func (this *UnionSearchResult) Page(offset, limit int) *HitPage {
+	return page(this, offset, limit)
+}
+
+func (this *UnionSearchResult) PageAfter(cursor string, limit int) (*HitPage, error) {
+	return pageAfter(this, cursor, limit)
+}
+
+func (this *UnionSearchResult) Documents() []int {
+	return this.positionIndex().distinctDocuments(this)
+}
+
+func (this *UnionSearchResult) DocumentHits(document int) []Hit {
+	return this.positionIndex().documentHits(this, document)
+}
+
+func (this *UnionSearchResult) document(hitIdx int) *Document {
+	result, i := this.locate(hitIdx)
+	return result.document(i)
+}
+
+func (this *UnionSearchResult) documentIndex(hitIdx int) int {
+	result, i := this.locate(hitIdx)
+	return result.documentIndex(i)
+}
+
+func (this *UnionSearchResult) globalPosition(hitIdx int) int {
+	result, i := this.locate(hitIdx)
+	return result.globalPosition(i)
+}
+
+func (this *UnionSearchResult) position(hitIdx int) int {
+	result, i := this.locate(hitIdx)
+	return result.position(i)
+}
+
+func (this *UnionSearchResult) charContext(hitIndex int, charsBefore, charsAfter int) HitContext {
+	result, i := this.locate(hitIndex)
+	return result.charContext(i, charsBefore, charsAfter)
+}
+
+func (this *UnionSearchResult) lineContext(hitIndex int, linesBefore, linesAfter int) HitContext {
+	result, i := this.locate(hitIndex)
+	return result.lineContext(i, linesBefore, linesAfter)
+}
+
+func (this *UnionSearchResult) lineIndex(hitIndex int) *lineIndex {
+	result, i := this.locate(hitIndex)
+	return result.lineIndex(i)
+}
@@ -0,0 +1,263 @@
+// Whitespace insensitive search
+package search
+
+// Search in which every run of whitespace characters both in the documents and
+// in the pattern is treated as a single space. The index is built over the
+// collapsed text, positions and contexts of the hits refer to the original text.
+// Unlike in MultiDocumentSearch, global positions of the hits are positions in the
+// original combined content, which contains no separators between the documents.
+type WhitespaceInsensitiveSearch struct {
+	search     Search
+	documents  []*Document
+	offsets    []int32   // document offsets in the original combined content
+	offsetMaps [][]int32 // for each document maps collapsed positions to original ones
+	lines      *lineIndexes
+}
+
+type WhitespaceInsensitiveSearchResult struct {
+	*WhitespaceInsensitiveSearch
+	result   SearchResult // result of the search in the collapsed text
+	posIndex lazyPositionIndex
+}
+
+func isWhitespace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\v' || c == '\f'
+}
+
+// Replaces every run of whitespace in data by a single space. Returns the collapsed
+// data and the offset map, where offsetMap[i] is the position in data where the
+// i-th collapsed character starts and offsetMap[len(collapsed)] is len(data).
+func collapseWhitespace(data []byte) ([]byte, []int32) {
+	collapsed := make([]byte, 0, len(data))
+	offsetMap := make([]int32, 0, len(data)+1)
+	for i := 0; i < len(data); i++ {
+		if isWhitespace(data[i]) {
+			if i > 0 && isWhitespace(data[i-1]) {
+				continue
+			}
+			collapsed = append(collapsed, ' ')
+		} else {
+			collapsed = append(collapsed, data[i])
+		}
+		offsetMap = append(offsetMap, int32(i))
+	}
+	offsetMap = append(offsetMap, int32(len(data)))
+	return collapsed, offsetMap
+}
+
+func CollapseWhitespace(pattern []byte) []byte {
+	collapsed, _ := collapseWhitespace(pattern)
+	return collapsed
+}
+
+func NewWhitespaceInsensitiveSingle(docId string, docContent []byte) (*WhitespaceInsensitiveSearch, error) {
+	collapsed, offsetMap := collapseWhitespace(docContent)
+	search, err := NewSingle(docId, collapsed)
+	if err != nil {
+		return nil, err
+	}
+	return &WhitespaceInsensitiveSearch{
+		search,
+		[]*Document{{0, docId, docContent}},
+		[]int32{0},
+		[][]int32{offsetMap},
+		newLineIndexes(1)}, nil
+}
+
+func NewWhitespaceInsensitiveMulti(combinedContent []byte, offsets []int, docIds []string) (*WhitespaceInsensitiveSearch, error) {
+	r := new(WhitespaceInsensitiveSearch)
+	r.documents = make([]*Document, len(offsets))
+	r.offsets = toint32(offsets)
+	r.offsetMaps = make([][]int32, len(offsets))
+	collapsedOffsets := make([]int, len(offsets))
+	var collapsedContent []byte
+	for i := range offsets {
+		end := len(combinedContent)
+		if i < len(offsets)-1 {
+			end = offsets[i+1]
+		}
+		content := combinedContent[offsets[i]:end]
+		collapsed, offsetMap := collapseWhitespace(content)
+		collapsedOffsets[i] = len(collapsedContent)
+		collapsedContent = append(collapsedContent, collapsed...)
+		r.documents[i] = &Document{i, docIds[i], content}
+		r.offsetMaps[i] = offsetMap
+	}
+	search, err := NewMulti(collapsedContent, collapsedOffsets, docIds)
+	if err != nil {
+		return nil, err
+	}
+	r.search = search
+	r.lines = newLineIndexes(len(offsets))
+	return r, nil
+}
+
+func (this *WhitespaceInsensitiveSearch) DocumentCount() int {
+	return len(this.documents)
+}
+
+func (this *WhitespaceInsensitiveSearch) Document(idx int) *Document {
+	if err := checkDocumentIndex(idx, len(this.documents)); err != nil {
+		panic(err)
+	}
+	doc := *this.documents[idx]
+	return &doc
+}
+
+func (this *WhitespaceInsensitiveSearch) Find(pattern []byte, options ...FindOption) SearchResult {
+	findOptions := newFindOptions(options)
+	result := this.search.Find(CollapseWhitespace(pattern), IgnoreCase(findOptions.IgnoreCase))
+	if result.IsEmpty() {
+		return EmptySearchResult(pattern)
+	}
+	return findOptions.filter(&WhitespaceInsensitiveSearchResult{WhitespaceInsensitiveSearch: this, result: result})
+}
+
+func (this *WhitespaceInsensitiveSearch) countHits(pattern []byte, options *FindOptions) int {
+	return estimateHits(this.search, CollapseWhitespace(pattern), options)
+}
+
+func (this *WhitespaceInsensitiveSearchResult) IsEmpty() bool {
+	return false
+}
+
+func (this *WhitespaceInsensitiveSearchResult) Size() int {
+	return this.result.Size()
+}
+
+func (this *WhitespaceInsensitiveSearchResult) Hit(hitIdx int) Hit {
+	return &HitStruct{this, hitIdx}
+}
+
+// Length of the collapsed pattern, the length of the hit in the original text may differ.
+func (this *WhitespaceInsensitiveSearchResult) PatternLength() int {
+	return this.result.PatternLength()
+}
+
+// The collapsed pattern
+func (this *WhitespaceInsensitiveSearchResult) Pattern() []byte {
+	return this.result.Pattern()
+}
+
+func (this *WhitespaceInsensitiveSearchResult) positionIndex() *positionIndex {
+	return this.posIndex.get(this)
+}
+
+func (this *WhitespaceInsensitiveSearchResult) heldHits() int {
+	return this.Size() + this.result.heldHits()
+}
+
+func (this *WhitespaceInsensitiveSearchResult) HasGlobalPosition(position int) bool {
+	return this.positionIndex().findGlobal(this, position) != -1
+}
+
+func (this *WhitespaceInsensitiveSearchResult) HitWithGlobalPosition(position int) Hit {
+	return hitOrNil(this, this.positionIndex().findGlobal(this, position))
+}
+
+func (this *WhitespaceInsensitiveSearchResult) HasPosition(document, position int) bool {
+	return this.positionIndex().find(this, document, position) != -1
+}
+
+func (this *WhitespaceInsensitiveSearchResult) HitWithPosition(document, position int) Hit {
+	return hitOrNil(this, this.positionIndex().find(this, document, position))
+}
+
+func (this *WhitespaceInsensitiveSearchResult) Positions() []int {
+	r := make([]int, this.Size())
+	for i := range r {
+		r[i] = this.position(i)
+	}
+	return r
+}
+
+func (this *WhitespaceInsensitiveSearchResult) Total() int {
+	return this.Size()
+}
+
+func (this *WhitespaceInsensitiveSearchResult) ForEachHit(f func(hit Hit) bool) {
+	forEachHit(this, f)
+}
+
+func (this *WhitespaceInsensitiveSearchResult) Page(offset, limit int) *HitPage {
+	return page(this, offset, limit)
+}
+
+func (this *WhitespaceInsensitiveSearchResult) PageAfter(cursor string, limit int) (*HitPage, error) {
+	return pageAfter(this, cursor, limit)
+}
+
+func (this *WhitespaceInsensitiveSearchResult) Documents() []int {
+	return this.positionIndex().distinctDocuments(this)
+}
+
+func (this *WhitespaceInsensitiveSearchResult) DocumentHits(document int) []Hit {
+	return this.positionIndex().documentHits(this, document)
+}
+
+func (this *WhitespaceInsensitiveSearchResult) documentIndex(hitIdx int) int {
+	return this.result.documentIndex(hitIdx)
+}
+
+func (this *WhitespaceInsensitiveSearchResult) document(hitIdx int) *Document {
+	return this.Document(this.documentIndex(hitIdx))
+}
+
+// Global position is the position in the original combined content without separators
+func (this *WhitespaceInsensitiveSearchResult) globalPosition(hitIdx int) int {
+	return int(this.offsets[this.documentIndex(hitIdx)]) + this.position(hitIdx)
+}
+
+func (this *WhitespaceInsensitiveSearchResult) position(hitIdx int) int {
+	return int(this.offsetMaps[this.documentIndex(hitIdx)][this.result.position(hitIdx)])
+}
+
+// Returns original document content, hit start and hit end
+func (this *WhitespaceInsensitiveSearchResult) hitBounds(hitIdx int) ([]byte, int32, int32) {
+	docIdx := this.documentIndex(hitIdx)
+	offsetMap := this.offsetMaps[docIdx]
+	collapsedPos := this.result.position(hitIdx)
+	return this.documents[docIdx].Content,
+		offsetMap[collapsedPos],
+		offsetMap[collapsedPos+this.result.PatternLength()]
+}
+
+func (this *WhitespaceInsensitiveSearchResult) charContext(hitIndex int, charsBefore, charsAfter int) HitContext {
+	if charsBefore < 0 || charsAfter < 0 {
+		panic(ErrNegativeContext)
+	}
+	data, start, end := this.hitBounds(hitIndex)
+	beforeStart := checkBeforeSingle(start, int32(charsBefore))
+	afterEnd := checkAfterSingle(int32(len(data)), end, int32(charsAfter))
+	return &HitContextStruct{
+		data,
+		beforeStart,
+		start - beforeStart,
+		end - start,
+		afterEnd - end,
+		this,
+		hitIndex,
+		0}
+}
+
+func (this *WhitespaceInsensitiveSearchResult) lineContext(hitIndex int, linesBefore, linesAfter int) HitContext {
+	if linesBefore < 0 || linesAfter < 0 {
+		panic(ErrNegativeContext)
+	}
+	data, start, end := this.hitBounds(hitIndex)
+	beforeStart := linesBeforeStartAt(data, start, linesBefore)
+	afterEnd := linesAfterStartAt(data, end, linesAfter)
+	return &HitContextStruct{
+		data,
+		beforeStart,
+		start - beforeStart,
+		end - start,
+		afterEnd - end,
+		this,
+		hitIndex,
+		0}
+}
+
+func (this *WhitespaceInsensitiveSearchResult) lineIndex(hitIndex int) *lineIndex {
+	return this.lines.get(this.documents[this.documentIndex(hitIndex)])
+}
@@ -0,0 +1,245 @@
+// HTTP interface of the index
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+
+	"github.com/mlinhard/exactly-index/query"
+	"github.com/mlinhard/exactly-index/search"
+)
+
+// Serves search requests:
+//
+//	GET /find?pattern=...[&icase=true][&word=true][&context=lines][&offset=n|&cursor=c][&limit=n]
+//	GET /query?q=...
+//
+// The cursor of a find response continues with the hits following the ones of the response.
+//
+// Invalid requests are answered with status 400 and errors of the index with status 500,
+// neither of them stops the server.
+type Server struct {
+	search     search.Search
+	mux        *http.ServeMux
+	MaxHits    int // Maximum number of hits returned by one find request
+	MaxContext int // Maximum number of context lines before and after a hit
+}
+
+type Hit struct {
+	Document string `json:"document"`
+	Position int    `json:"position"`
+	Line     int    `json:"line"`
+	Column   int    `json:"column"`
+	Before   string `json:"before"`
+	Pattern  string `json:"pattern"`
+	After    string `json:"after"`
+}
+
+type FindResponse struct {
+	Total  int    `json:"total"`
+	Hits   []Hit  `json:"hits"`
+	Cursor string `json:"cursor,omitempty"`
+}
+
+type DocumentMatch struct {
+	Document string `json:"document"`
+	Hits     []int  `json:"hits"` // number of hits of each positive term of the query
+}
+
+type QueryResponse struct {
+	Terms     []string        `json:"terms"`
+	Documents []DocumentMatch `json:"documents"`
+}
+
+type ErrorResponse struct {
+	Error    string `json:"error"`
+	Position *int   `json:"position,omitempty"` // of the syntax error in the query
+}
+
+func New(index search.Search) *Server {
+	server := &Server{search: index, mux: http.NewServeMux(), MaxHits: 1000, MaxContext: 10}
+	server.mux.HandleFunc("/find", server.find)
+	server.mux.HandleFunc("/query", server.query)
+	return server
+}
+
+func (this *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	Recover(this.mux).ServeHTTP(w, r)
+}
+
+// Answers requests whose handler panicked with status 500 instead of crashing the server. If the
+// handler has already started the response, it can't be replaced, so it's only logged.
+func Recover(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tracked := &trackingWriter{ResponseWriter: w}
+		defer func() {
+			if p := recover(); p != nil {
+				if p == http.ErrAbortHandler {
+					panic(p)
+				}
+				log.Printf("Panic serving %v: %v\n%s", r.URL, p, debug.Stack())
+				if !tracked.written {
+					writeJSON(w, http.StatusInternalServerError, &ErrorResponse{Error: fmt.Sprintf("Internal error: %v", p)})
+				}
+			}
+		}()
+		next.ServeHTTP(tracked, r)
+	})
+}
+
+// Response writer remembering whether the response has been started
+type trackingWriter struct {
+	http.ResponseWriter
+	written bool
+}
+
+func (this *trackingWriter) WriteHeader(status int) {
+	this.written = true
+	this.ResponseWriter.WriteHeader(status)
+}
+
+func (this *trackingWriter) Write(b []byte) (int, error) {
+	this.written = true
+	return this.ResponseWriter.Write(b)
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Printf("Error writing response: %v", err)
+	}
+}
+
+func badRequest(w http.ResponseWriter, err error) {
+	response := &ErrorResponse{Error: err.Error()}
+	var syntaxErr *query.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		response.Position = &syntaxErr.Position
+	}
+	writeJSON(w, http.StatusBadRequest, response)
+}
+
+// Non-negative integer parameter not greater than max
+func intParam(r *http.Request, name string, def, max int) (int, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 || n > max {
+		return 0, fmt.Errorf("Parameter %v must be an integer in [0, %v]", name, max)
+	}
+	return n, nil
+}
+
+func boolParam(r *http.Request, name string) (bool, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("Parameter %v must be a boolean", name)
+	}
+	return b, nil
+}
+
+func (this *Server) findOptions(r *http.Request) ([]search.FindOption, error) {
+	icase, err := boolParam(r, "icase")
+	if err != nil {
+		return nil, err
+	}
+	word, err := boolParam(r, "word")
+	if err != nil {
+		return nil, err
+	}
+	options := []search.FindOption{search.IgnoreCase(icase)}
+	if word {
+		options = append(options, search.Match(search.WholeWord))
+	}
+	return options, nil
+}
+
+func (this *Server) find(w http.ResponseWriter, r *http.Request) {
+	options, err := this.findOptions(r)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	context, err := intParam(r, "context", 0, this.MaxContext)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	offset, err := intParam(r, "offset", 0, 1<<30)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	limit, err := intParam(r, "limit", this.MaxHits, this.MaxHits)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	result, err := search.TryFind(this.search, []byte(r.URL.Query().Get("pattern")), options...)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	page, err := this.page(result, r.URL.Query().Get("cursor"), offset, limit)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	response := &FindResponse{Total: result.Size(), Hits: make([]Hit, len(page.Hits)), Cursor: page.Cursor}
+	for i, hit := range page.Hits {
+		ctx, err := search.TryLineContext(hit, context, context)
+		if err != nil {
+			badRequest(w, err)
+			return
+		}
+		response.Hits[i] = Hit{hit.Document().Id, hit.Position(), hit.Line(), hit.Column(),
+			string(ctx.Before()), string(ctx.Pattern()), string(ctx.After())}
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+func (this *Server) page(result search.SearchResult, cursor string, offset, limit int) (*search.HitPage, error) {
+	if cursor == "" {
+		return search.TryPage(result, offset, limit)
+	}
+	if offset != 0 {
+		return nil, errors.New("Parameters offset and cursor can't be combined")
+	}
+	return result.PageAfter(cursor, limit)
+}
+
+func (this *Server) query(w http.ResponseWriter, r *http.Request) {
+	result, err := query.Run(this.search, r.URL.Query().Get("q"))
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	response := &QueryResponse{Terms: make([]string, len(result.Terms)), Documents: make([]DocumentMatch, len(result.Documents))}
+	for i, term := range result.Terms {
+		response.Terms[i] = string(term)
+	}
+	for i, match := range result.Documents {
+		document, err := search.TryDocument(this.search, match.Document)
+		if err != nil {
+			badRequest(w, err)
+			return
+		}
+		response.Documents[i] = DocumentMatch{document.Id, make([]int, len(match.Hits))}
+		for j, hits := range match.Hits {
+			response.Documents[i].Hits[j] = len(hits)
+		}
+	}
+	writeJSON(w, http.StatusOK, response)
+}
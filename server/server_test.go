@@ -0,0 +1,122 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/mlinhard/exactly-index/search"
+)
+
+func testServer(t *testing.T) *Server {
+	texts := []string{"foo bar\nFoo baz", "bar foo"}
+	combined := []byte(texts[0] + texts[1])
+	index, err := search.NewMulti(combined, []int{0, len(texts[0])}, []string{"a.txt", "b.txt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return New(index)
+}
+
+func get(t *testing.T, handler http.Handler, path string, status int, body interface{}) {
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", path, nil))
+	if recorder.Code != status {
+		t.Errorf("Expected status %v of %v but got %v: %v", status, path, recorder.Code, recorder.Body)
+		return
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), body); err != nil {
+		t.Errorf("Invalid response to %v: %v", path, err)
+	}
+}
+
+func TestFind(t *testing.T) {
+	server := testServer(t)
+	var response FindResponse
+	get(t, server, "/find?pattern=foo&icase=true&context=1", http.StatusOK, &response)
+	if response.Total != 3 || len(response.Hits) != 3 {
+		t.Fatalf("Unexpected response %+v", response)
+	}
+	if hit := response.Hits[1]; hit.Document != "a.txt" || hit.Line != 2 || hit.Column != 1 || hit.Before != "foo bar\n" || hit.Pattern != "Foo" {
+		t.Errorf("Unexpected hit %+v", hit)
+	}
+	get(t, server, "/find?pattern=foo&limit=1&offset=1", http.StatusOK, &response)
+	if response.Total != 2 || len(response.Hits) != 1 || response.Hits[0].Document != "b.txt" {
+		t.Errorf("Unexpected response %+v", response)
+	}
+	for _, path := range []string{"/find?pattern=", "/find?pattern=foo&context=-1", "/find?pattern=foo&limit=x",
+		"/find?pattern=foo&context=1000", "/find?pattern=foo&word=maybe"} {
+		var e ErrorResponse
+		get(t, server, path, http.StatusBadRequest, &e)
+		if e.Error == "" {
+			t.Errorf("Expected error message for %v", path)
+		}
+	}
+}
+
+func TestFindCursor(t *testing.T) {
+	server := testServer(t)
+	documents := make([]string, 0)
+	path := "/find?pattern=foo&icase=true&limit=1"
+	for i := 0; i < 5; i++ {
+		var response FindResponse
+		get(t, server, path, http.StatusOK, &response)
+		for _, hit := range response.Hits {
+			documents = append(documents, hit.Document)
+		}
+		if response.Cursor == "" {
+			break
+		}
+		path = "/find?pattern=foo&icase=true&limit=1&cursor=" + url.QueryEscape(response.Cursor)
+	}
+	if fmt.Sprint(documents) != "[a.txt a.txt b.txt]" {
+		t.Errorf("Unexpected hits of the pages %v", documents)
+	}
+	for _, path := range []string{"/find?pattern=foo&cursor=x", "/find?pattern=foo&cursor=h1&offset=1"} {
+		var e ErrorResponse
+		get(t, server, path, http.StatusBadRequest, &e)
+		if e.Error == "" {
+			t.Errorf("Expected error message for %v", path)
+		}
+	}
+}
+
+func TestQuery(t *testing.T) {
+	server := testServer(t)
+	var response QueryResponse
+	get(t, server, "/query?q="+url.QueryEscape("bar NOT baz"), http.StatusOK, &response)
+	if len(response.Documents) != 1 || response.Documents[0].Document != "b.txt" || response.Documents[0].Hits[0] != 1 {
+		t.Errorf("Unexpected response %+v", response)
+	}
+	var e ErrorResponse
+	get(t, server, "/query?q="+url.QueryEscape("foo AND (bar"), http.StatusBadRequest, &e)
+	if e.Position == nil || *e.Position != 12 {
+		t.Errorf("Expected syntax error position but got %+v", e)
+	}
+}
+
+func TestRecover(t *testing.T) {
+	handler := Recover(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		search.EmptySearchResult("x").Hit(0)
+	}))
+	var e ErrorResponse
+	get(t, handler, "/", http.StatusInternalServerError, &e)
+	if e.Error == "" {
+		t.Errorf("Expected error message")
+	}
+}
+
+func TestRecoverAfterPartialResponse(t *testing.T) {
+	handler := Recover(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, &ErrorResponse{Error: "partial"})
+		search.EmptySearchResult("x").Hit(0)
+	}))
+	var e ErrorResponse
+	get(t, handler, "/", http.StatusOK, &e)
+	if e.Error != "partial" {
+		t.Errorf("Expected the response started by the handler but got %+v", e)
+	}
+}